@@ -0,0 +1,159 @@
+package gbtb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// bootstrapEnv is set for a build program executed by Bootstrap, to make sure
+// it is not rebuilt again
+const bootstrapEnv = "GBTB_BOOTSTRAPPED"
+
+// BootstrapTags are build tags used when rebuilding build program
+var BootstrapTags = []string{"make"}
+
+// findModuleFiles returns go.mod and go.sum of a module containing dir
+func findModuleFiles(dir string) []string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			files := []string{filepath.Join(dir, "go.mod")}
+			if _, err := os.Stat(filepath.Join(dir, "go.sum")); err == nil {
+				files = append(files, filepath.Join(dir, "go.sum"))
+			}
+			return files
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+func newestModTime(files []string) (time.Time, error) {
+	var newest time.Time
+	for _, fn := range files {
+		st, err := os.Stat(fn)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if st.ModTime().After(newest) {
+			newest = st.ModTime()
+		}
+	}
+	return newest, nil
+}
+
+func bootstrapBinary(sources []string, exe string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, src := range sources {
+		fmt.Fprintln(h, src)
+	}
+	name := filepath.Base(exe)
+	if runtime.GOOS == "windows" && !strings.HasSuffix(name, ".exe") {
+		name += ".exe"
+	}
+	return filepath.Join(cacheDir, "gbtb", "bin", hex.EncodeToString(h.Sum(nil))[:16], name), nil
+}
+
+func bootstrapBuild(target string, sources []string) error {
+	var goFiles []string
+	for _, src := range sources {
+		if strings.HasSuffix(src, ".go") {
+			goFiles = append(goFiles, src)
+		}
+	}
+	if len(goFiles) == 0 {
+		return fmt.Errorf("no go files to build build program from")
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	args := []string{"build", "-o", target}
+	if len(BootstrapTags) > 0 {
+		args = append(args, "-tags", strings.Join(BootstrapTags, ","))
+	}
+	cmd := exec.Command("go", append(args, goFiles...)...)
+	cmd.Dir = filepath.Dir(goFiles[0])
+	return PipeCommands(cmd)
+}
+
+// Bootstrap keeps a compiled build program up to date with it's sources.
+// If any of sources, or go.mod and go.sum of a module they belong to, are newer
+// than running program, build program is rebuilt with BootstrapTags into user
+// cache directory and executed with the same arguments in place of a running one.
+// If no sources are provided, a file calling Bootstrap is used.
+// Bootstrap should be called at the very beginning of main. It returns only
+// if running program is up to date or it failed to rebuild program.
+func Bootstrap(sources ...string) error {
+	return bootstrap(sources)
+}
+
+// bootstrap must be called directly by exported Bootstrap functions, so that
+// it can find a caller of Bootstrap
+func bootstrap(sources []string) error {
+	if os.Getenv(bootstrapEnv) != "" {
+		// do not leak into commands ran by build program
+		return os.Unsetenv(bootstrapEnv)
+	}
+	sources = append([]string{}, sources...)
+	if len(sources) == 0 {
+		_, file, _, ok := runtime.Caller(2)
+		if !ok {
+			return fmt.Errorf("could not determine build program source")
+		}
+		sources = []string{file}
+	}
+	for i := range sources {
+		abs, err := filepath.Abs(sources[i])
+		if err != nil {
+			return err
+		}
+		sources[i] = abs
+	}
+	deps := append(append([]string{}, sources...), findModuleFiles(filepath.Dir(sources[0]))...)
+	newest, err := newestModTime(deps)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if t, err := newestModTime([]string{exe}); err == nil && !t.Before(newest) {
+		return nil
+	}
+	target, err := bootstrapBinary(sources, exe)
+	if err != nil {
+		return err
+	}
+	if t, err := newestModTime([]string{target}); err != nil || t.Before(newest) {
+		fmt.Printf("rebuilding build program %s\n", target)
+		if err := bootstrapBuild(target, sources); err != nil {
+			return err
+		}
+	}
+	if err := os.Setenv(bootstrapEnv, "1"); err != nil {
+		return err
+	}
+	return execBootstrapped(target, os.Args[1:])
+}
+
+// MustBootstrap calls Bootstrap and exits process with status 1 on error
+func MustBootstrap(sources ...string) {
+	if err := bootstrap(sources); err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package gbtb
+
+import (
+	"os"
+	"syscall"
+)
+
+// execBootstrapped replaces running process with program
+func execBootstrapped(program string, args []string) error {
+	return syscall.Exec(program, append([]string{program}, args...), os.Environ())
+}
@@ -0,0 +1,25 @@
+//go:build windows
+// +build windows
+
+package gbtb
+
+import (
+	"os"
+	"os/exec"
+)
+
+// execBootstrapped runs program and exits with it's exit code, windows
+// can not replace running process
+func execBootstrapped(program string, args []string) error {
+	cmd := exec.Command(program, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		os.Exit(exitErr.ExitCode())
+	}
+	if err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
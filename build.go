@@ -135,6 +135,12 @@ func (tasks Tasks) Do(taskNames ...string) (err error) {
 	if len(taskNames) == 0 {
 		taskNames = []string{allNames[0]}
 	}
+	// commands run in their own process groups, interrupts are forwarded to them
+	// and processes left running by them are killed on exit
+	defer processes.killAll()
+	defer forwardInterrupts()()
+	// services required by long running tasks are stopped once they finish
+	defer requiredServices.stopAll()
 	failedTasks := newFailedTasks()
 	go failedTasks.run()
 	if err = tasks.execute(taskNames, allNames, &failedTasks); err == nil {
@@ -142,6 +148,8 @@ func (tasks Tasks) Do(taskNames ...string) (err error) {
 			err = fmt.Errorf("tasks %v failed", failedTasks)
 		}
 	}
+	// services started by tasks keep running until stopped
+	backgroundServices.Wait()
 	return
 }
 
@@ -158,6 +166,15 @@ func (tasks Tasks) RunWithFlags(flagSet *flag.FlagSet, args ...string) error {
 	if err := flagSet.Parse(args); err != nil {
 		return err
 	}
+	if ok, err := tasks.completion(flagSet); ok || err != nil {
+		return err
+	}
+	if controlFlag != "" {
+		return control(controlFlag, flagSet.Args())
+	}
+	if err := loadEnvFlags(); err != nil {
+		return err
+	}
 	return tasks.Do(flagSet.Args()...)
 }
 
@@ -186,7 +203,15 @@ func FlagsInit(flagSet *flag.FlagSet) {
 	if flagSet == nil {
 		flagSet = flag.CommandLine
 	}
-	flag.IntVar(&Jobs, "jobs", runtime.NumCPU(), "maximum number of jubs that can be run in parallel")
+	flagSet.IntVar(&Jobs, "jobs", runtime.NumCPU(), "maximum number of jubs that can be run in parallel")
+	flagSet.Var(&envFilesFlag, "env-file", "load environment variables from dotenv file, can be repeated")
+	flagSet.BoolVar(&Pty, "pty", false, "run commands under pseudo-terminal to preserve colors when output is a terminal")
+	flagSet.BoolVar(&WatchPoll, "watch-poll", false, "poll file system for changes instead of using file system notifications")
+	flagSet.DurationVar(&WatchPollInterval, "watch-poll-interval", time.Second, "interval between file system scans when polling for changes")
+	flagSet.StringVar(&controlFlag, "control", "", "send command (rebuild, restart, pause, resume or status) to watch sessions named in arguments, or all running in working directory, and exit")
+	flagSet.StringVar(&completionFlag, "completion", "", "print completion script for shell (bash, zsh or fish) and exit")
+	flagSet.BoolVar(&completeFlag, "complete", false, "print flags and task names for shell completion and exit")
+	flagSet.StringVar(&profileFlag, "profile", "", "load environment variables from .env, .env.local, .env.<profile> and .env.<profile>.local")
 }
 
 // Run run the tasks using command line args
@@ -0,0 +1,353 @@
+package gbtb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cache stores outputs of tasks under a key computed from task inputs
+type Cache interface {
+	// Restore outputs stored under key, returns false if there's no entry for key
+	Restore(key string, outputs []string) (bool, error)
+	// Store outputs under key
+	Store(key string, outputs []string) error
+}
+
+// CacheKey describes inputs of a task used to compute a cache key
+type CacheKey struct {
+	// Name of a task
+	Name string
+	// Dependencies of a task, files are hashed by their content, tasks by their
+	// sum in TaskSums and other dependencies by their name
+	Dependencies []string
+	// TaskSums maps dependencies that are tasks to a sum of their outputs
+	// content, or of their mod time if they have no outputs
+	TaskSums map[string]string
+	// Fingerprint of a job
+	Fingerprint string
+	// Dir is a working directory of a job
+	Dir string
+	// JobEnv is a list of environment variables in form of KEY=VALUE set
+	// for a job, check out JobEnv.Env
+	JobEnv []string
+	// Env is a list of environment variable names included in a key, their
+	// values are resolved like for commands of a job, from JobEnv, process
+	// environment and dotenv files
+	Env []string
+	// Outputs of a task
+	Outputs []string
+}
+
+func hashFile(h io.Writer, fn string) (bool, error) {
+	st, err := os.Stat(fn)
+	if err != nil || !st.Mode().IsRegular() {
+		return false, nil
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	_, err = io.Copy(h, f)
+	return err == nil, err
+}
+
+// Sum returns a hex encoded sha256 of a key
+func (c CacheKey) Sum() (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "name:%s\nfingerprint:%s\n", c.Name, c.Fingerprint)
+	deps := append([]string{}, c.Dependencies...)
+	sort.Strings(deps)
+	for _, dep := range deps {
+		fh := sha256.New()
+		ok, err := hashFile(fh, dep)
+		if err != nil {
+			return "", err
+		}
+		sum, isTask := c.TaskSums[dep]
+		switch {
+		case ok:
+			fmt.Fprintf(h, "file:%s:%x\n", dep, fh.Sum(nil))
+		case isTask:
+			fmt.Fprintf(h, "task:%s:%s\n", dep, sum)
+		default:
+			fmt.Fprintf(h, "dep:%s\n", dep)
+		}
+	}
+	fmt.Fprintf(h, "dir:%s\n", c.Dir)
+	// later variables override earlier ones, like for commands of a job
+	jobValues := make(map[string]string, len(c.JobEnv))
+	for _, e := range c.JobEnv {
+		if i := strings.Index(e, "="); i >= 0 {
+			jobValues[e[:i]] = e[i+1:]
+		}
+	}
+	jobEnv := make([]string, 0, len(jobValues))
+	for k, v := range jobValues {
+		jobEnv = append(jobEnv, k+"="+v)
+	}
+	sort.Strings(jobEnv)
+	for _, e := range jobEnv {
+		fmt.Fprintf(h, "jobenv:%s\n", e)
+	}
+	resolved := exec.Cmd{Env: append([]string{}, c.JobEnv...)}
+	addEnv(&resolved)
+	values := make(map[string]string, len(resolved.Env))
+	for _, e := range resolved.Env {
+		if i := strings.Index(e, "="); i >= 0 {
+			values[e[:i]] = e[i+1:]
+		}
+	}
+	env := append([]string{}, c.Env...)
+	sort.Strings(env)
+	for _, e := range env {
+		fmt.Fprintf(h, "env:%s=%s\n", e, values[e])
+	}
+	for _, o := range c.Outputs {
+		fmt.Fprintf(h, "output:%s\n", o)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// taskSum returns a sum of a result of a dependency task, outputs are hashed
+// by their content. Without outputs, modTime returned by a task is used.
+func taskSum(outputs []string, modTime time.Time) (string, error) {
+	if len(outputs) == 0 {
+		return modTime.UTC().Format(time.RFC3339Nano), nil
+	}
+	h := sha256.New()
+	for _, o := range outputs {
+		sum, err := fileSum(o)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "output:%s:%s\n", o, sum)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type cacheEntryOutput struct {
+	Path string      `json:"path"`
+	Hash string      `json:"hash"`
+	Size int64       `json:"size"`
+	Mode os.FileMode `json:"mode"`
+}
+
+type cacheEntry struct {
+	Outputs []cacheEntryOutput `json:"outputs"`
+}
+
+// selectOutputs returns entry outputs matching requested outputs in order,
+// false if any of requested outputs is missing in entry
+func (e cacheEntry) selectOutputs(outputs []string) ([]cacheEntryOutput, bool) {
+	byPath := make(map[string]cacheEntryOutput, len(e.Outputs))
+	for _, o := range e.Outputs {
+		byPath[filepath.Clean(o.Path)] = o
+	}
+	selected := make([]cacheEntryOutput, 0, len(outputs))
+	for _, path := range outputs {
+		o, ok := byPath[filepath.Clean(path)]
+		if !ok {
+			return nil, false
+		}
+		o.Path = path
+		selected = append(selected, o)
+	}
+	return selected, true
+}
+
+// LocalCache is a content addressed cache of task outputs stored in a directory.
+// Entries describing outputs for a key are stored in Dir/ac, output contents
+// are stored in Dir/cas under their sha256.
+type LocalCache struct {
+	// Dir is a root directory of a cache
+	Dir string
+}
+
+// NewLocalCache returns a cache in gbtb subdirectory of user cache directory,
+// usually ~/.cache/gbtb
+func NewLocalCache() (*LocalCache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &LocalCache{Dir: filepath.Join(dir, "gbtb")}, nil
+}
+
+func (l *LocalCache) acPath(key string) string {
+	return filepath.Join(l.Dir, "ac", key)
+}
+
+func (l *LocalCache) casPath(hash string) string {
+	return filepath.Join(l.Dir, "cas", hash[:2], hash)
+}
+
+// writeTempFile writes contents of r to a temporary file next to dst and
+// returns it's name, so that it can be renamed to dst once complete
+func writeTempFile(dst string, r io.Reader, mode os.FileMode) (name string, err error) {
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return
+	}
+	out, err := ioutil.TempFile(filepath.Dir(dst), ".gbtb-")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(out.Name())
+		}
+	}()
+	if _, err = io.Copy(out, r); err != nil {
+		out.Close()
+		return
+	}
+	if err = out.Close(); err != nil {
+		return
+	}
+	if err = os.Chmod(out.Name(), mode); err != nil {
+		return
+	}
+	return out.Name(), nil
+}
+
+// writeFile writes contents of r to dst through a temporary file, so that dst
+// is never left partially written
+func writeFile(dst string, r io.Reader, mode os.FileMode) error {
+	name, err := writeTempFile(dst, r, mode)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(name, dst); err != nil {
+		os.Remove(name)
+		return err
+	}
+	return nil
+}
+
+func copyFile(dst, src string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeFile(dst, in, mode)
+}
+
+func fileSum(fn string) (string, error) {
+	h := sha256.New()
+	if _, err := hashFile(h, fn); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Restore outputs for key from local cache
+func (l *LocalCache) Restore(key string, outputs []string) (bool, error) {
+	b, err := ioutil.ReadFile(l.acPath(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return false, err
+	}
+	selected, ok := entry.selectOutputs(outputs)
+	if !ok {
+		return false, nil
+	}
+	// make sure that all blobs are present before touching any output
+	for _, o := range selected {
+		if _, err := os.Stat(l.casPath(o.Hash)); err != nil {
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return false, err
+		}
+	}
+	for _, o := range selected {
+		if err := copyFile(o.Path, l.casPath(o.Hash), o.Mode); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Store outputs in local cache under key
+func (l *LocalCache) Store(key string, outputs []string) error {
+	var entry cacheEntry
+	for _, o := range outputs {
+		st, err := os.Stat(o)
+		if err != nil {
+			return err
+		}
+		if !st.Mode().IsRegular() {
+			return fmt.Errorf("output %s is not a regular file", o)
+		}
+		sum, err := fileSum(o)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(l.casPath(sum)); os.IsNotExist(err) {
+			if err := copyFile(l.casPath(sum), o, 0644); err != nil {
+				return err
+			}
+		}
+		entry.Outputs = append(entry.Outputs, cacheEntryOutput{
+			Path: o,
+			Hash: sum,
+			Size: st.Size(),
+			Mode: st.Mode().Perm(),
+		})
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.acPath(key)), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(l.acPath(key), b, 0644)
+}
+
+// CacheList is a list of caches checked in order. Outputs are restored from the
+// first cache having an entry for a key and stored in all of them.
+type CacheList []Cache
+
+// Restore outputs from the first cache that has them
+func (cl CacheList) Restore(key string, outputs []string) (bool, error) {
+	var firstErr error
+	for _, c := range cl {
+		hit, err := c.Restore(key, outputs)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if hit {
+			return true, nil
+		}
+	}
+	return false, firstErr
+}
+
+// Store outputs in all caches
+func (cl CacheList) Store(key string, outputs []string) error {
+	var firstErr error
+	for _, c := range cl {
+		if err := c.Store(key, outputs); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,98 @@
+package gbtb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLocalCacheRestoresRequestedOutputs(t *testing.T) {
+	dir := inTempDir(t)
+	cache := &LocalCache{Dir: filepath.Join(dir, "cache")}
+	writeTestFile(t, "a.txt", "a", 0644)
+	writeTestFile(t, "b.txt", "b", 0644)
+	if err := cache.Store("key", []string{"a.txt", "b.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, "a.txt", "old a", 0644)
+	writeTestFile(t, "b.txt", "old b", 0644)
+	hit, err := cache.Restore("key", []string{"a.txt"})
+	if err != nil || !hit {
+		t.Fatalf("expected hit, got %v, %v", hit, err)
+	}
+	if got := readTestFile(t, "a.txt"); got != "a" {
+		t.Errorf("a.txt restored as %q", got)
+	}
+	if got := readTestFile(t, "b.txt"); got != "old b" {
+		t.Errorf("b.txt was not requested but restored as %q", got)
+	}
+	hit, err = cache.Restore("key", []string{"a.txt", "c.txt"})
+	if err != nil || hit {
+		t.Fatalf("expected miss for missing output, got %v, %v", hit, err)
+	}
+}
+
+func TestCacheKeyDependsOnTaskOutputs(t *testing.T) {
+	inTempDir(t)
+	writeTestFile(t, "gen.txt", "1", 0644)
+	key := func() string {
+		sum, err := taskSum([]string{"gen.txt"}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := CacheKey{
+			Name:         "app",
+			Dependencies: []string{"gen"},
+			TaskSums:     map[string]string{"gen": sum},
+		}.Sum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	first := key()
+	writeTestFile(t, "gen.txt", "2", 0644)
+	if key() == first {
+		t.Error("cache key did not change with outputs of a dependency task")
+	}
+	os.Remove("gen.txt")
+	writeTestFile(t, "gen.txt", "1", 0644)
+	if key() != first {
+		t.Error("cache key changed for the same outputs of a dependency task")
+	}
+}
+
+func TestCacheKeyDependsOnJobEnvironment(t *testing.T) {
+	sum := func(k CacheKey) string {
+		s, err := k.Sum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	base := CacheKey{Name: "app", Dir: "a", JobEnv: []string{"TASK_NAME=app", "GBTB_TEST_FOO=1"}, Env: []string{"GBTB_TEST_BAR"}}
+	key := sum(base)
+	dir := base
+	dir.Dir = "b"
+	if sum(dir) == key {
+		t.Error("cache key did not change with working directory")
+	}
+	env := base
+	env.JobEnv = []string{"TASK_NAME=app", "GBTB_TEST_FOO=2"}
+	if sum(env) == key {
+		t.Error("cache key did not change with job environment")
+	}
+	reordered := base
+	reordered.JobEnv = []string{"GBTB_TEST_FOO=1", "TASK_NAME=app"}
+	if sum(reordered) != key {
+		t.Error("cache key depends on order of job environment")
+	}
+	defer func(saved []string) { dotenv = saved }(dotenv)
+	dotenv = []string{"GBTB_TEST_BAR=1"}
+	fromDotenv := sum(base)
+	dotenv = []string{"GBTB_TEST_BAR=2"}
+	if sum(base) == fromDotenv {
+		t.Error("cache key did not change with value of a variable from dotenv")
+	}
+}
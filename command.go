@@ -8,7 +8,6 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/buildkite/interpolate"
 )
@@ -37,8 +36,21 @@ var (
 	stderr = newLineSynchronizedWriter(&sync.Mutex{}, os.Stderr)
 )
 
-func addEnv(cmd *exec.Cmd) {
+// baseEnv returns process environment along with variables loaded from
+// dotenv files that are not set in process environment
+func baseEnv() []string {
 	envs := append([]string{}, os.Environ()...)
+	for _, de := range dotenv {
+		key := de[:strings.Index(de, "=")]
+		if _, ok := os.LookupEnv(key); !ok {
+			envs = append(envs, de)
+		}
+	}
+	return envs
+}
+
+func addEnv(cmd *exec.Cmd) {
+	envs := baseEnv()
 	for _, ue := range cmd.Env {
 		ekey := ue[:strings.Index(ue, "=")+1]
 		pop := -1
@@ -61,12 +73,16 @@ func RunCommand(cmd string, args ...string) error {
 	return PipeCommands(exec.Command(cmd, args...))
 }
 
-func pipeCommands(cmds ...*exec.Cmd) (err error) {
+// pipeCommands starts commands, each in it's own process group while interrupts
+// are forwarded, check out ownProcessGroup. If forward is set commands get interrupts
+// of gbtb forwarded. If pty is set, last command is started under pseudo-terminal,
+// check out Pty.
+func pipeCommands(forward, pty bool, cmds ...*exec.Cmd) (err error) {
 	defer func() {
 		if err != nil {
 			for _, cmd := range cmds {
 				if cmd.Process != nil {
-					cmd.Process.Kill()
+					killProcess(cmd)
 				}
 			}
 		}
@@ -75,7 +91,10 @@ func pipeCommands(cmds ...*exec.Cmd) (err error) {
 		return nil
 	}
 	first, last := cmds[0], cmds[len(cmds)-1]
-	if last.Stdout == nil {
+	// pseudo-terminal sets output of last command on start, it's session
+	// does not get interrupts from terminal
+	pty = pty && usePty(last) && ownProcessGroup(last)
+	if last.Stdout == nil && !pty {
 		last.Stdout = stdout
 	}
 	in := first.Stdin
@@ -99,29 +118,53 @@ func pipeCommands(cmds ...*exec.Cmd) (err error) {
 			cmd.Stdout = pw
 			in = pr
 		}
-		if cmd.Stderr == nil {
+		if cmd.Stderr == nil && !(pty && cmd == last) {
 			cmd.Stderr = stderr
 		}
-		if err = cmd.Start(); err != nil {
+		// commands left in foreground process group get interrupts from terminal
+		own := ownProcessGroup(cmd)
+		if own {
+			setProcessGroup(cmd)
+		}
+		if err = startCommand(cmd, pty && cmd == last); err != nil {
 			return
 		}
+		processes.add(cmd, forward && own)
 	}
 	return nil
 }
 
-// PipeCommands check out documentation for PipeCommandsContext
-func PipeCommands(cmds ...*exec.Cmd) (err error) {
-	if err := pipeCommands(cmds...); err != nil {
-		return err
-	}
+// waitCommands waits for started commands, on failure process groups of all commands
+// are killed so that no process started by them is left running
+func waitCommands(cmds ...*exec.Cmd) (err error) {
 	for _, cmd := range cmds {
 		if cerr := cmd.Wait(); cerr != nil && err == nil {
 			err = cerr
 		}
+		closePty(cmd)
+	}
+	for _, cmd := range cmds {
+		if err != nil {
+			killProcess(cmd)
+		}
+		processes.done(cmd)
 	}
 	return
 }
 
+// PipeCommands check out documentation for PipeCommandsContext
+func PipeCommands(cmds ...*exec.Cmd) (err error) {
+	return runCommands(Pty, cmds...)
+}
+
+// runCommands runs commands until they finish, last one under pseudo-terminal if pty is set
+func runCommands(pty bool, cmds ...*exec.Cmd) error {
+	if err := pipeCommands(true, pty, cmds...); err != nil {
+		return err
+	}
+	return waitCommands(cmds...)
+}
+
 // PipeCommandsContext runs a list of commands piping input from previous command to
 // output of the following one.
 // If first command in a list has Stdin set, it will be used as a Stdin for the first
@@ -138,38 +181,12 @@ func PipeCommands(cmds ...*exec.Cmd) (err error) {
 // Function appends current environment flags to command without overriding those
 // already defined.
 // On context done function attempts to terminate proccess with os.Interrupt, if process
-// does not terminate in 10 seconds, it gets killed.
+// does not terminate in 10 seconds, it gets killed. Check out StopOptions to change that.
+// Each command runs in it's own process group, on unix signals are sent to the whole
+// group so that processes started by a command, like a server started by a shell,
+// are stopped as well.
 func PipeCommandsContext(ctx context.Context, cmds ...*exec.Cmd) (err error) {
-	if err := pipeCommands(cmds...); err != nil {
-		return err
-	}
-	wait := make(chan error)
-	go func() {
-		var err error
-		for _, cmd := range cmds {
-			if cerr := cmd.Wait(); cerr != nil && err == nil {
-				err = cerr
-			}
-		}
-		wait <- err
-	}()
-	select {
-	case err = <-wait:
-	case <-ctx.Done():
-		for _, cmd := range cmds {
-			cmd.Process.Signal(os.Interrupt)
-		}
-		timer := time.NewTimer(time.Second * 10)
-		select {
-		case <-timer.C:
-			for _, cmd := range cmds {
-				cmd.Process.Kill()
-			}
-			err = <-wait
-		case err = <-wait:
-		}
-	}
-	return
+	return StopOptions{}.PipeCommandsContext(ctx, cmds...)
 }
 
 // OutputPipe runs a command pipe and returns the stdout of last command panicing on error
@@ -204,11 +221,11 @@ func MustOutput(cmd string, args ...string) []byte {
 
 // RunStoppable Runs a command with an ability to stop it
 func RunStoppable(stop chan struct{}, name string, args ...string) error {
-	ctx, cancel := context.WithCancel(context.Background())
-	cmd := exec.Command(name, args...)
-	go func() {
-		<-stop
-		cancel()
-	}()
-	return PipeCommandsContext(ctx, cmd)
+	return StopOptions{}.RunStoppable(stop, name, args...)
+}
+
+// RunStoppableCommands runs a command pipe until it finishes or stop is closed,
+// check out PipeCommandsContext
+func RunStoppableCommands(stop chan struct{}, cmds ...*exec.Cmd) error {
+	return StopOptions{}.RunStoppableCommands(stop, cmds...)
 }
@@ -0,0 +1,89 @@
+package gbtb
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var (
+	completionFlag string
+	completeFlag   bool
+)
+
+const bashCompletion = `_gbtb_complete_%[1]s() {
+	local cur="${COMP_WORDS[COMP_CWORD]}"
+	COMPREPLY=($(compgen -W "$("${COMP_WORDS[0]}" -complete 2>/dev/null)" -- "$cur"))
+}
+complete -F _gbtb_complete_%[1]s %[2]s
+`
+
+const zshCompletion = `#compdef %[2]s
+_gbtb_complete_%[1]s() {
+	local -a candidates
+	candidates=(${(f)"$(${words[1]} -complete 2>/dev/null)"})
+	compadd -a candidates
+}
+compdef _gbtb_complete_%[1]s %[2]s
+`
+
+const fishCompletion = `function __gbtb_complete_%[1]s
+	set -l cmd (commandline -opc)[1]
+	$cmd -complete 2>/dev/null
+end
+complete -c %[2]s -f -a '(__gbtb_complete_%[1]s)'
+`
+
+var nonIdentifier = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// WriteCompletion writes a completion script for shell (bash, zsh or fish)
+// completing flags and task names of build program named name. Script gets
+// candidates by running build program with -complete flag.
+func WriteCompletion(w io.Writer, shell, name string) error {
+	var script string
+	switch shell {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	case "fish":
+		script = fishCompletion
+	default:
+		return fmt.Errorf("unsupported shell %s, expected bash, zsh or fish", shell)
+	}
+	_, err := fmt.Fprintf(w, script, nonIdentifier.ReplaceAllString(name, "_"), name)
+	return err
+}
+
+// writeCompletionCandidates writes flags defined in flagSet and task names,
+// one per line
+func (tasks Tasks) writeCompletionCandidates(w io.Writer, flagSet *flag.FlagSet) error {
+	names, err := tasks.definedTasks()
+	if err != nil {
+		return err
+	}
+	flagSet.VisitAll(func(f *flag.Flag) {
+		if f.Name != "complete" {
+			fmt.Fprintf(w, "-%s\n", f.Name)
+		}
+	})
+	for _, name := range names {
+		fmt.Fprintln(w, name)
+	}
+	return nil
+}
+
+// completion handles -completion and -complete flags, returns true if
+// one of them was handled
+func (tasks Tasks) completion(flagSet *flag.FlagSet) (bool, error) {
+	if completionFlag != "" {
+		return true, WriteCompletion(os.Stdout, completionFlag, filepath.Base(os.Args[0]))
+	}
+	if completeFlag {
+		return true, tasks.writeCompletionCandidates(os.Stdout, flagSet)
+	}
+	return false, nil
+}
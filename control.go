@@ -0,0 +1,316 @@
+package gbtb
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Commands accepted by a control socket of a watch session
+const (
+	// ControlRebuild rebuilds all watched tasks
+	ControlRebuild = "rebuild"
+	// ControlRestart restarts a service of NotifyLongRunning without rebuilding it
+	ControlRestart = "restart"
+	// ControlPause stops rebuilding on changes, changes are collected until resumed
+	ControlPause = "pause"
+	// ControlResume resumes rebuilding on changes, collected changes are rebuilt
+	ControlResume = "resume"
+	// ControlStatus returns status of a watch session
+	ControlStatus = "status"
+)
+
+var controlFlag string
+
+// ControlRequest is a request sent to a control socket as a single line of JSON
+type ControlRequest struct {
+	Command string `json:"command"`
+}
+
+// ControlResponse is a response to a ControlRequest
+type ControlResponse struct {
+	OK     bool         `json:"ok"`
+	Error  string       `json:"error,omitempty"`
+	Status *WatchStatus `json:"status,omitempty"`
+}
+
+// WatchStatus describes a state of a watch session
+type WatchStatus struct {
+	Name      string     `json:"name"`
+	Tasks     []string   `json:"tasks"`
+	Paused    bool       `json:"paused"`
+	Building  bool       `json:"building"`
+	Pending   int        `json:"pending"`
+	Builds    int        `json:"builds"`
+	LastBuild *time.Time `json:"last_build,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// controlSocketDir returns a directory of control sockets accessible only by
+// the user, gbtb in $XDG_RUNTIME_DIR or gbtb-<uid> in temporary directory
+func controlSocketDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "gbtb")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("gbtb-%d", os.Getuid()))
+}
+
+// makeControlSocketDir creates a directory of control sockets, making sure
+// it's a directory of the user that no one else can access
+func makeControlSocketDir() error {
+	dir := controlSocketDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	st, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !st.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	// only owner can change mode, so it fails for directory of another user
+	return os.Chmod(dir, 0700)
+}
+
+// ControlSocketPath returns a path of control socket of a watch session
+// named name started in working directory
+func ControlSocketPath(name string) string {
+	wd, _ := os.Getwd()
+	sum := sha256.Sum256([]byte(wd))
+	name = "gbtb-" + hex.EncodeToString(sum[:])[:12] + "-" + nonIdentifier.ReplaceAllString(name, "_")
+	return filepath.Join(controlSocketDir(), name+".sock")
+}
+
+// watchControl is a state of a watch session controlled through a control socket
+type watchControl struct {
+	// wake is signaled when watch session should look at control state
+	wake chan struct{}
+	// restart restarts a service without a rebuild, nil if not supported
+	restart func() error
+
+	lock       sync.Mutex
+	status     WatchStatus
+	rebuildAll bool
+	pending    map[string]struct{}
+	listener   net.Listener
+}
+
+func newWatchControl(name string, roots map[string]TaskLike, restart func() error) *watchControl {
+	c := &watchControl{
+		wake:    make(chan struct{}, 1),
+		restart: restart,
+		pending: make(map[string]struct{}),
+		status:  WatchStatus{Name: name},
+	}
+	for t := range roots {
+		c.status.Tasks = append(c.status.Tasks, t)
+	}
+	sort.Strings(c.status.Tasks)
+	return c
+}
+
+func (c *watchControl) poke() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take returns changes collected while paused and whether all tasks
+// should be rebuilt, if session is paused changed are collected and
+// false is returned
+func (c *watchControl) take(changed map[string]struct{}) (map[string]struct{}, bool, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for name := range changed {
+		c.pending[name] = struct{}{}
+	}
+	if c.status.Paused {
+		c.status.Pending = len(c.pending)
+		return nil, false, false
+	}
+	pending, all := c.pending, c.rebuildAll
+	c.pending, c.rebuildAll, c.status.Pending = make(map[string]struct{}), false, 0
+	return pending, all, len(pending) > 0 || all
+}
+
+func (c *watchControl) buildStarted() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.status.Building = true
+}
+
+func (c *watchControl) buildFinished(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.status.Building = false
+	c.status.Builds++
+	now := time.Now()
+	c.status.LastBuild = &now
+	c.status.LastError = ""
+	if err != nil {
+		c.status.LastError = err.Error()
+	}
+}
+
+func (c *watchControl) handle(req ControlRequest) ControlResponse {
+	if req.Command == ControlRestart {
+		// restart can take a while, do not block status
+		if c.restart == nil {
+			return ControlResponse{Error: fmt.Sprintf("%s can not be restarted", c.status.Name)}
+		}
+		if err := c.restart(); err != nil {
+			return ControlResponse{Error: err.Error()}
+		}
+		return ControlResponse{OK: true}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	switch req.Command {
+	case ControlRebuild:
+		c.rebuildAll = true
+		c.poke()
+	case ControlPause:
+		c.status.Paused = true
+	case ControlResume:
+		c.status.Paused = false
+		c.poke()
+	case ControlStatus:
+		status := c.status
+		status.Tasks = append([]string{}, c.status.Tasks...)
+		return ControlResponse{OK: true, Status: &status}
+	default:
+		return ControlResponse{Error: fmt.Sprintf("unknown command %s", req.Command)}
+	}
+	return ControlResponse{OK: true}
+}
+
+func (c *watchControl) serveConn(conn net.Conn) {
+	defer conn.Close()
+	var req ControlRequest
+	var resp ControlResponse
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &req)
+	}
+	if err != nil {
+		resp = ControlResponse{Error: err.Error()}
+	} else {
+		resp = c.handle(req)
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// listen starts serving control socket. If socket can not be created
+// session works without it.
+func (c *watchControl) listen() {
+	if err := makeControlSocketDir(); err != nil {
+		fmt.Printf("could not create control socket: %v\n", err)
+		return
+	}
+	path := ControlSocketPath(c.status.Name)
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		fmt.Printf("control socket %s is used by another session\n", path)
+		return
+	}
+	// left over by a session that did not exit cleanly
+	if st, err := os.Lstat(path); err == nil && st.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		fmt.Printf("could not create control socket: %v\n", err)
+		return
+	}
+	c.listener = l
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go c.serveConn(conn)
+		}
+	}()
+}
+
+func (c *watchControl) Close() error {
+	if c.listener == nil {
+		return nil
+	}
+	return c.listener.Close()
+}
+
+// SendControl sends a command to a control socket of a watch session named name
+func SendControl(name, command string) (ControlResponse, error) {
+	return sendControl(ControlSocketPath(name), command)
+}
+
+func sendControl(path, command string) (ControlResponse, error) {
+	var resp ControlResponse
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return resp, err
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(ControlRequest{Command: command}); err != nil {
+		return resp, err
+	}
+	err = json.NewDecoder(conn).Decode(&resp)
+	return resp, err
+}
+
+// runningSessions returns names of watch sessions running in working directory
+func runningSessions() []string {
+	prefix := strings.TrimSuffix(filepath.Base(ControlSocketPath("")), ".sock")
+	matches, _ := filepath.Glob(filepath.Join(controlSocketDir(), prefix+"*.sock"))
+	var names []string
+	for _, m := range matches {
+		names = append(names, strings.TrimSuffix(strings.TrimPrefix(filepath.Base(m), prefix), ".sock"))
+	}
+	return names
+}
+
+// control handles -control flag, sending command to sessions named in args
+// or to all sessions running in working directory
+func control(command string, names []string) error {
+	if len(names) == 0 {
+		names = runningSessions()
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no watch sessions running")
+	}
+	var failed []string
+	for _, name := range names {
+		resp, err := SendControl(name, command)
+		if err == nil && !resp.OK {
+			err = fmt.Errorf("%s", resp.Error)
+		}
+		if err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+			failed = append(failed, name)
+			continue
+		}
+		if resp.Status != nil {
+			b, _ := json.MarshalIndent(resp.Status, "", "  ")
+			fmt.Printf("%s\n", b)
+		} else {
+			fmt.Printf("%s: ok\n", name)
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("control %s failed for %v", command, failed)
+	}
+	return nil
+}
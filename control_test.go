@@ -0,0 +1,55 @@
+//go:build !windows
+// +build !windows
+
+package gbtb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestControlSocketInPrivateDirectory(t *testing.T) {
+	runtimeDir := inTempDir(t)
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	c := newWatchControl("watch", nil, nil)
+	c.listen()
+	if c.listener == nil {
+		t.Fatal("control socket was not created")
+	}
+	defer c.Close()
+	st, err := os.Stat(controlSocketDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm() != 0700 {
+		t.Errorf("control socket directory has mode %v", st.Mode().Perm())
+	}
+	resp, err := SendControl("watch", ControlStatus)
+	if err != nil || !resp.OK || resp.Status.Name != "watch" {
+		t.Errorf("unexpected status response %+v, %v", resp, err)
+	}
+	if names := runningSessions(); len(names) != 1 || names[0] != "watch" {
+		t.Errorf("unexpected running sessions %v", names)
+	}
+}
+
+func TestControlSocketKeepsOtherFiles(t *testing.T) {
+	runtimeDir := inTempDir(t)
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	if err := makeControlSocketDir(); err != nil {
+		t.Fatal(err)
+	}
+	path := ControlSocketPath("watch")
+	writeTestFile(t, path, "not a socket", 0600)
+	c := newWatchControl("watch", nil, nil)
+	c.listen()
+	if c.listener != nil {
+		c.Close()
+		t.Fatal("control socket replaced a regular file")
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil || string(b) != "not a socket" {
+		t.Errorf("regular file at socket path was changed: %q, %v", b, err)
+	}
+}
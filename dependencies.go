@@ -2,6 +2,8 @@ package gbtb
 
 import (
 	"fmt"
+	"path/filepath"
+	"strings"
 
 	"github.com/bmatcuk/doublestar"
 )
@@ -35,11 +37,47 @@ func (d DependencyFunc) TargetDependencies(string) Dependencies {
 	return d
 }
 
-// GlobFiles returns a glob dependency supporting double start (**) matching
-func GlobFiles(pattern string) DependencyFunc {
-	return DependencyFunc(func() ([]string, error) {
-		return doublestar.Glob(pattern)
-	})
+// WatchedDependencies is implemented by dependencies resolved from contents
+// of directories. Notify watches returned directories and re-evaluates
+// dependencies when their contents change. Directories ending with "/..."
+// are watched recursively.
+type WatchedDependencies interface {
+	WatchDirs() []string
+}
+
+// Glob is a dependency on files matching a pattern supporting double star (**) matching
+type Glob string
+
+func (g Glob) Get() ([]string, error) {
+	return doublestar.Glob(string(g))
+}
+
+func (g Glob) TargetDependencies(string) Dependencies {
+	return g
+}
+
+// WatchDirs returns a directory which is a static prefix of a pattern,
+// recursive if pattern matches files in subdirectories
+func (g Glob) WatchDirs() []string {
+	dir, pattern := ".", filepath.ToSlash(string(g))
+	if strings.HasPrefix(pattern, "/") {
+		dir, pattern = "/", pattern[1:]
+	}
+	components := strings.Split(pattern, "/")
+	for len(components) > 1 && !strings.ContainsAny(components[0], "*?[{\\") {
+		dir = filepath.Join(dir, components[0])
+		components = components[1:]
+	}
+	if len(components) > 1 {
+		dir += string(filepath.Separator) + "..."
+	}
+	return []string{dir}
+}
+
+// GlobFiles returns a glob dependency supporting double start (**) matching,
+// Notify watches directories files matching pattern can be created in
+func GlobFiles(pattern string) Glob {
+	return Glob(pattern)
 }
 
 // DependenciesList is a helper to easly build multiple dependencies for target
@@ -67,6 +105,17 @@ func (dc DependenciesList) TargetDependencies(string) Dependencies {
 	return dc
 }
 
+// WatchDirs returns directories watched by dependencies in a list
+func (dc DependenciesList) WatchDirs() []string {
+	var dirs []string
+	for _, d := range dc {
+		if wd, ok := d.(WatchedDependencies); ok {
+			dirs = append(dirs, wd.WatchDirs()...)
+		}
+	}
+	return dirs
+}
+
 // NewDependenciesList returns a new dependency chain
 func NewDependenciesList(d Dependencies, nd ...Dependencies) DependenciesList {
 	return DependenciesList{d}.Append(nd...)
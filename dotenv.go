@@ -0,0 +1,221 @@
+package gbtb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/buildkite/interpolate"
+)
+
+// dotenv holds variables loaded from dotenv files in form of KEY=VALUE.
+// Process environment takes precedence over variables loaded from files.
+var dotenv []string
+
+type envFiles []string
+
+func (e *envFiles) String() string {
+	return strings.Join(*e, ",")
+}
+
+func (e *envFiles) Set(v string) error {
+	*e = append(*e, v)
+	return nil
+}
+
+var (
+	envFilesFlag envFiles
+	profileFlag  string
+)
+
+func lookupEnv(env []string, key string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], key+"=") {
+			return env[i][len(key)+1:], true
+		}
+	}
+	return "", false
+}
+
+// dotenvEnv is a view of environment used when expanding dotenv values,
+// process environment first, then already loaded variables
+type dotenvEnv []string
+
+func (d dotenvEnv) Get(key string) (string, bool) {
+	if v, ok := os.LookupEnv(key); ok {
+		return v, true
+	}
+	return lookupEnv(d, key)
+}
+
+func parseDotenvValue(env interpolate.Env, s string) (value, rest string, err error) {
+	switch {
+	case strings.HasPrefix(s, "'"):
+		end := strings.Index(s[1:], "'")
+		if end == -1 {
+			return "", "", fmt.Errorf("unterminated single quoted value")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	case strings.HasPrefix(s, "\""):
+		var sb strings.Builder
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					sb.WriteByte('\n')
+				case 't':
+					sb.WriteByte('\t')
+				case 'r':
+					sb.WriteByte('\r')
+				case '$':
+					// keep escaped $ for interpolation
+					sb.WriteString("$$")
+				default:
+					sb.WriteByte(s[i])
+				}
+				continue
+			}
+			sb.WriteByte(s[i])
+		}
+		if i == len(s) {
+			return "", "", fmt.Errorf("unterminated double quoted value")
+		}
+		value, err = interpolate.Interpolate(env, sb.String())
+		return value, s[i+1:], err
+	}
+	end := strings.IndexByte(s, '\n')
+	if end == -1 {
+		end = len(s)
+	}
+	value, rest = s[:end], s[end:]
+	// comments in unquoted values must be preceded by a whitespace
+	for i := 1; i < len(value); i++ {
+		if value[i] == '#' && unicode.IsSpace(rune(value[i-1])) {
+			value = value[:i]
+			break
+		}
+	}
+	value, err = interpolate.Interpolate(env, strings.TrimSpace(value))
+	return
+}
+
+// ParseDotenv parses contents of a dotenv file, returning variables in form
+// of KEY=VALUE. Values are expanded using env and variables defined earlier
+// in the file.
+// Supported syntax:
+//
+//	# comment
+//	KEY=value # comment
+//	export KEY=value
+//	KEY='literal ${NOT_EXPANDED}'
+//	KEY="multi
+//	line with ${EXPANDED} and \n escapes"
+func ParseDotenv(content string, env []string) ([]string, error) {
+	var vars []string
+	lineNo := 1
+	s := content
+	for len(s) > 0 {
+		line := s
+		if i := strings.IndexByte(s, '\n'); i != -1 {
+			line = s[:i]
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			s = s[len(line):]
+			s = strings.TrimPrefix(s, "\n")
+			lineNo++
+			continue
+		}
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		s = strings.TrimPrefix(s, "export ")
+		eq := strings.IndexByte(s, '=')
+		if eq == -1 || strings.ContainsAny(s[:eq], "\n") {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNo)
+		}
+		key := strings.TrimSpace(s[:eq])
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: invalid variable name %q", lineNo, key)
+		}
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		value, rest, err := parseDotenvValue(dotenvEnv(append(append([]string{}, env...), vars...)), s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		lineNo += strings.Count(s[:len(s)-len(rest)], "\n")
+		// allow trailing comment after quoted value
+		if i := strings.IndexByte(rest, '\n'); i != -1 {
+			if tail := strings.TrimSpace(rest[:i]); tail != "" && !strings.HasPrefix(tail, "#") {
+				return nil, fmt.Errorf("line %d: unexpected %q after value", lineNo, tail)
+			}
+			rest = rest[i:]
+		} else if tail := strings.TrimSpace(rest); tail != "" && !strings.HasPrefix(tail, "#") {
+			return nil, fmt.Errorf("line %d: unexpected %q after value", lineNo, tail)
+		} else {
+			rest = ""
+		}
+		vars = append(vars, key+"="+value)
+		s = rest
+	}
+	return vars, nil
+}
+
+// LoadDotenv loads variables from dotenv files into environment of commands
+// ran by gbtb. Variables from later files override those from earlier ones,
+// but variables set in process environment always take precedence over
+// dotenv files. LoadDotenv must be called before running tasks.
+func LoadDotenv(files ...string) error {
+	for _, fn := range files {
+		b, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return err
+		}
+		vars, err := ParseDotenv(string(b), dotenv)
+		if err != nil {
+			return fmt.Errorf("%s: %v", fn, err)
+		}
+		dotenv = append(dotenv, vars...)
+	}
+	return nil
+}
+
+// ProfileFiles returns existing dotenv files for profile in order
+// of loading: .env, .env.local, .env.<profile>, .env.<profile>.local.
+// If profile is empty only .env and .env.local are returned.
+func ProfileFiles(profile string) []string {
+	candidates := []string{".env", ".env.local"}
+	if profile != "" {
+		candidates = append(candidates, ".env."+profile, ".env."+profile+".local")
+	}
+	var files []string
+	for _, fn := range candidates {
+		if st, err := os.Stat(fn); err == nil && st.Mode().IsRegular() {
+			files = append(files, fn)
+		}
+	}
+	return files
+}
+
+// LoadProfile loads dotenv files for profile, check out ProfileFiles and LoadDotenv
+func LoadProfile(profile string) error {
+	if profile != "" {
+		if _, err := os.Stat(".env." + profile); err != nil {
+			return fmt.Errorf("profile %s: %v", profile, err)
+		}
+	}
+	return LoadDotenv(ProfileFiles(profile)...)
+}
+
+// loadEnvFlags loads dotenv files selected with -profile and -env-file flags.
+// Files named with -env-file are loaded after profile files.
+func loadEnvFlags() error {
+	if profileFlag != "" {
+		if err := LoadProfile(profileFlag); err != nil {
+			return err
+		}
+	}
+	return LoadDotenv(envFilesFlag...)
+}
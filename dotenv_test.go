@@ -0,0 +1,90 @@
+package gbtb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	env := []string{"GBTB_TEST_HOST=localhost"}
+	for _, tc := range []struct {
+		name    string
+		content string
+		vars    []string
+	}{
+		{"empty", "", nil},
+		{"comments and blank lines", "# comment\n\n   \n  # indented\nA=1\n", []string{"A=1"}},
+		{"export", "export A=1\n  export B=2", []string{"A=1", "B=2"}},
+		{"spaces around value", "A =  value  \n", []string{"A=value"}},
+		{"empty value", "A=\nB=''\nC=\"\"", []string{"A=", "B=", "C="}},
+		{"trailing comment", "A=value # comment", []string{"A=value"}},
+		{"hash inside value", "A=a#b", []string{"A=a#b"}},
+		{"single quoted", "A='literal ${GBTB_TEST_HOST} \\n # not a comment'", []string{"A=literal ${GBTB_TEST_HOST} \\n # not a comment"}},
+		{"double quoted escapes", `A="tab\tnew\nline \"quoted\" \\ \$HOME"`, []string{"A=tab\tnew\nline \"quoted\" \\ $HOME"}},
+		{"double quoted multi line", "A=\"first\nsecond\"\nB=2", []string{"A=first\nsecond", "B=2"}},
+		{"comment after quoted value", "A=\"x\" # comment\nB='y'   # comment", []string{"A=x", "B=y"}},
+		{"expands env", "A=http://${GBTB_TEST_HOST}:80", []string{"A=http://localhost:80"}},
+		{"expands earlier variables", "A=1\nB=${A}2\nC=\"${B}3\"", []string{"A=1", "B=12", "C=123"}},
+		{"later variable overrides", "A=1\nA=2\nB=$A", []string{"A=1", "A=2", "B=2"}},
+		{"default value", "A=${GBTB_TEST_UNSET:-fallback}", []string{"A=fallback"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			vars, err := ParseDotenv(tc.content, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(vars, tc.vars) {
+				t.Errorf("got %q, expected %q", vars, tc.vars)
+			}
+		})
+	}
+}
+
+func TestParseDotenvErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"missing equals", "A=1\nNOVALUE\n", "line 2: expected KEY=VALUE"},
+		{"empty name", "=1", "line 1: invalid variable name"},
+		{"name with space", "\n\nA B=1", "line 3: invalid variable name"},
+		{"unterminated single quote", "A='value", "line 1: unterminated single quoted value"},
+		{"unterminated double quote", "A=1\nB=\"value\n", "line 2: unterminated double quoted value"},
+		{"text after quoted value", "A='x' y", "line 1: unexpected \"y\" after value"},
+		{"line after multi line value", "A=\"1\n2\n3\"\nBAD", "line 4: expected KEY=VALUE"},
+		{"text after multi line value", "# c\nA=\"1\n2\" x\n", "line 3: unexpected \"x\" after value"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseDotenv(tc.content, nil)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestParseDotenvProcessEnvironmentTakesPrecedence(t *testing.T) {
+	t.Setenv("GBTB_TEST_A", "process")
+	vars, err := ParseDotenv("GBTB_TEST_A=file\nB=${GBTB_TEST_A}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"GBTB_TEST_A=file", "B=process"}; !reflect.DeepEqual(vars, expected) {
+		t.Errorf("got %q, expected %q", vars, expected)
+	}
+}
+
+func TestProfileFiles(t *testing.T) {
+	inTempDir(t)
+	for _, fn := range []string{".env", ".env.dev", ".env.dev.local", ".env.prod"} {
+		writeTestFile(t, fn, "", 0644)
+	}
+	if files, expected := ProfileFiles("dev"), []string{".env", ".env.dev", ".env.dev.local"}; !reflect.DeepEqual(files, expected) {
+		t.Errorf("got %v, expected %v", files, expected)
+	}
+	if files, expected := ProfileFiles(""), []string{".env"}; !reflect.DeepEqual(files, expected) {
+		t.Errorf("got %v, expected %v", files, expected)
+	}
+}
@@ -14,7 +14,7 @@ func main() {
 		},
 		&gbtb.Task{ // Task that is always out-of-date and prints some output to stdout
 			Name: "task-with-output",
-			Job:  gbtb.CommandJob("/bin/sh", "-c", "echo 'hello world'"),
+			Job:  gbtb.ShellJob("echo 'hello world'"),
 		},
 		&gbtb.Task{ // Task dependant on files matching glob pattern, build
 			Name:         "app",
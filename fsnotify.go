@@ -1,22 +1,46 @@
 package gbtb
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
-
-	"gopkg.in/fsnotify.v1"
 )
 
-// Notify task is a blocking task that runs named task when change in file system is detected
+// Notify task is a blocking task that runs named tasks when change in file system is detected.
+// Dependencies of tasks are re-evaluated after every change, so new files matching
+// Glob dependencies are picked up and directories they are in are watched.
+// A change triggers only tasks depending on a changed file, triggered tasks are
+// run in parallel.
 type Notify struct {
 	// Name of notify task
 	Name string
 	// Job is a name of task to run on change
 	Job string
+	// Jobs is a list of names of tasks to run on change, in addition to Job
+	Jobs []string
+	// Debounce is a quiet period after the last change before task is rebuilt,
+	// defaults to one second
+	Debounce time.Duration
+	// Ignore is a list of glob patterns of paths which changes are ignored,
+	// for example editor swap files or build outputs. Patterns without a slash
+	// match a name at any level, just like in .gitignore.
+	Ignore []string
+	// GitIgnore adds patterns from .gitignore in working directory to Ignore
+	GitIgnore bool
+	// IgnoreOps is a set of operations that do not trigger a rebuild,
+	// for example WatchChmod
+	IgnoreOps WatchOp
+	// LiveReload is an address of live reload server, for example ":35729".
+	// Browsers are notified about every rebuild with Server-Sent Events,
+	// include LiveReloadSnippet in development pages to reload them.
+	LiveReload string
 
+	// Task overrides a task named Job
 	Task TaskLike
 }
 
@@ -24,184 +48,204 @@ func (n *Notify) GetNames() []string {
 	return []string{n.Name}
 }
 
-type watcher struct {
-	*fsnotify.Watcher
-	fileChange chan struct{}
-}
-
-func (w *watcher) watch(fdeps map[string]struct{}, done chan error, stop chan struct{}) {
-	var err error
-	defer func() {
-		close(w.fileChange)
-		done <- err
-	}()
+// collectChanges waits for debounce period after the last change adding
+// changes to changed, returns false if changes got closed
+func collectChanges(debounce time.Duration, changes chan string, changed map[string]struct{}) bool {
+	// Wait a while to collect events because editors like vim
+	// can generate quite a few events in very short period of time
+	// for one write
+	timer := time.NewTimer(debounce)
+	defer timer.Stop()
 	for {
 		select {
-		case <-stop:
-			return
-		case ev, ok := <-w.Events:
+		case <-timer.C:
+			// timer expired, don't wait any longer
+			return true
+		case name, ok := <-changes:
 			if !ok {
-				err = fmt.Errorf("watcher error")
-				return
-			}
-			if _, ok := fdeps[ev.Name]; ok {
-				fileModTimeCache.Delete(ev.Name)
-				if ev.Op == fsnotify.Create {
-					w.Add(ev.Name)
-				}
-				w.fileChange <- struct{}{}
+				return false
 			}
-		case _, ok := <-w.Errors:
-			if !ok {
-				err = fmt.Errorf("watcher error")
-				return
+			// new change, wait again
+			changed[name] = struct{}{}
+			if !timer.Stop() {
+				<-timer.C
 			}
+			timer.Reset(debounce)
 		}
 	}
 }
 
-func newWatcher(fileChange chan struct{}) (*watcher, error) {
-	w, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, err
+// rebuild resets affected tasks and runs affected watched tasks in parallel.
+// Tasks not affected by a change stay done.
+func (n *Notify) rebuild(tasks Tasks, runner *Runner, session *watchSession, affected map[string]struct{}) error {
+	var triggered []string
+	for td := range affected {
+		if t, ok := session.roots[td]; ok {
+			triggered = append(triggered, td)
+			t.Reset()
+		} else if t := tasks.getTask(td); t != nil {
+			t.Reset()
+		}
+	}
+	var lock sync.Mutex
+	var failed []string
+	wg := sync.WaitGroup{}
+	for _, name := range triggered {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if _, err := session.roots[name].Do(tasks, runner); err != nil {
+				fmt.Println(err)
+				lock.Lock()
+				failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+				lock.Unlock()
+			}
+		}(name)
 	}
-	return &watcher{w, fileChange}, nil
+	wg.Wait()
+	if len(failed) != 0 {
+		sort.Strings(failed)
+		return errors.New(strings.Join(failed, "\n"))
+	}
+	return nil
 }
 
-func (n *Notify) build(tasks Tasks, runner *Runner, tDeps map[string]struct{}, fileChange chan struct{}) {
-	for range fileChange {
-		// Wait a second to collect events because editors like vim
-		// can generate quite a few events in very short period of time
-		// for one write
-		wait := true
-		for wait {
-			timer := time.NewTimer(time.Second)
-			select {
-			case <-timer.C:
-				// timer expired, don't wait any longer
-				wait = false
-			case <-fileChange:
-				// new change, wait another second
+// build rebuilds watched tasks on every change of their dependencies
+// or when requested through control socket
+func (n *Notify) build(
+	tasks Tasks,
+	runner *Runner,
+	session *watchSession,
+	control *watchControl,
+	reload *liveReload,
+	changes chan string,
+) {
+	for {
+		changed := make(map[string]struct{})
+		select {
+		case name, ok := <-changes:
+			if !ok {
+				return
 			}
-		}
-		for td := range tDeps {
-			if t := tasks.getTask(td); t != nil {
-				t.Reset()
+			changed[name] = struct{}{}
+			if !collectChanges(session.opts.debounce, changes, changed) {
+				return
 			}
+		case <-control.wake:
+		}
+		changed, all, ok := control.take(changed)
+		if !ok {
+			// paused
+			continue
 		}
-		n.Task.Reset()
-		_, err := n.Task.Do(tasks, runner)
+		// file could have been removed or a new one matching dependencies
+		// could have been created
+		affected, err := session.refresh(changed)
 		if err != nil {
 			fmt.Println(err)
+			continue
 		}
+		if all {
+			affected = session.allTasks()
+		}
+		if len(affected) == 0 {
+			continue
+		}
+		control.buildStarted()
+		err = n.rebuild(tasks, runner, session, affected)
+		control.buildFinished(err)
+		reload.broadcast(err)
 	}
 }
 
 func (n *Notify) job(
 	tasks Tasks,
 	runner *Runner,
-	fdeps map[string]struct{},
-	tDeps map[string]struct{},
+	session *watchSession,
+	control *watchControl,
 	stop chan struct{},
 ) error {
-	// Watch base directories for each watch because certain
-	// which recreate file on
-	dWatch := make(map[string]struct{})
-	for d := range fdeps {
-		bd := filepath.Dir(d)
-		if _, ok := fdeps[bd]; !ok {
-			dWatch[bd] = struct{}{}
+	control.listen()
+	defer control.Close()
+	var reload *liveReload
+	if n.LiveReload != "" {
+		var err error
+		if reload, err = newLiveReload(n.LiveReload); err != nil {
+			return err
 		}
+		defer reload.Close()
 	}
-
-	fileChange := make(chan struct{})
-	watcher, err := newWatcher(fileChange)
-	if err != nil {
-		return err
-	}
-	defer watcher.Close()
-
+	changes := make(chan string)
 	done := make(chan error)
-	go watcher.watch(fdeps, done, stop)
+	go func() {
+		done <- session.watch(changes, stop)
+	}()
+	n.build(tasks, runner, session, control, reload, changes)
+	return <-done
+}
 
-	for d := range fdeps {
-		err := watcher.Add(d)
-		if err != nil {
-			return err
-		}
-	}
-	for d := range dWatch {
-		err := watcher.Add(d)
-		if err != nil {
-			return err
+// interruptChannel returns a channel closed on os.Interrupt or SIGTERM and
+// a function releasing signal handler
+func interruptChannel() (chan struct{}, func()) {
+	stop := make(chan struct{})
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, stopSignals...)
+	go func() {
+		if _, ok := <-c; ok {
+			close(stop)
 		}
+	}()
+	return stop, func() {
+		signal.Stop(c)
+		close(c)
 	}
-
-	n.build(tasks, runner, tDeps, fileChange)
-	return <-done
 }
 
-func (n *Notify) deps(tasks Tasks, task TaskLike) (
-	fileDeps map[string]struct{},
-	taskDeps map[string]struct{},
-	err error) {
-	if task == nil {
-		err = fmt.Errorf("task %s does not exist", n.Job)
-		return
+// roots returns watched tasks by their names
+func (n *Notify) roots(tasks Tasks) (map[string]TaskLike, error) {
+	roots := make(map[string]TaskLike)
+	names := n.Jobs
+	if n.Task != nil {
+		roots[n.Job] = n.Task
+	} else if n.Job != "" {
+		names = append([]string{n.Job}, names...)
 	}
-	fileDeps = make(map[string]struct{})
-	taskDeps = make(map[string]struct{})
-	deps, err := task.DependsOn().Get()
-	if err == nil {
-		for _, dep := range deps {
-			if tDep := tasks.getTask(dep); tDep != nil {
-				var fDeps map[string]struct{}
-				var tDeps map[string]struct{}
-				fDeps, tDeps, err = n.deps(tasks, tDep)
-				if err != nil {
-					break
-				}
-				for k := range fDeps {
-					fileDeps[k] = struct{}{}
-				}
-				taskDeps[dep] = struct{}{}
-				for k := range tDeps {
-					taskDeps[k] = struct{}{}
-				}
-			} else {
-				fileDeps[dep] = struct{}{}
-			}
+	for _, name := range names {
+		t := tasks.getTask(name)
+		if t == nil {
+			return nil, fmt.Errorf("task \"%s\" does not exist", name)
 		}
+		roots[name] = t
 	}
-	return
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("notify %s does not watch any task", n.Name)
+	}
+	return roots, nil
 }
 
 func (n *Notify) Do(tasks Tasks, runner *Runner) (time.Time, error) {
-	if n.Task == nil {
-		n.Task = tasks.getTask(n.Job)
-		if n.Task == nil {
-			return time.Time{}, fmt.Errorf("task \"%s\" does not exist", n.Job)
-		}
+	roots, err := n.roots(tasks)
+	if err != nil {
+		return time.Time{}, err
 	}
-	fdeps, tdeps, err := n.deps(tasks, n.Task)
+	opts, err := newWatchOptions(n.Debounce, n.Ignore, n.GitIgnore, n.IgnoreOps)
 	if err != nil {
 		return time.Time{}, err
 	}
-	stop := make(chan struct{})
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	go func() {
-		<-c
-		close(stop)
-	}()
+	session, err := newWatchSession(tasks, roots, opts)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer session.Close()
+	stop, release := interruptChannel()
+	defer release()
 	err = n.job(
 		tasks,
 		runner,
-		fdeps,
-		tdeps,
+		session,
+		newWatchControl(n.Name, roots, nil),
 		stop,
 	)
-	close(c)
 	// Notify is always out of date
 	return time.Time{}, err
 }
@@ -1,11 +1,18 @@
 module github.com/Dennor/gbtb
 
-go 1.13
+go 1.19
 
 require (
 	github.com/bmatcuk/doublestar v1.1.5
 	github.com/buildkite/interpolate v0.0.0-20181028012610-973457fa2b4c
-	github.com/fsnotify/fsnotify v1.4.7 // indirect
-	golang.org/x/sys v0.0.0-20191113165036-4c7a9d0fe056 // indirect
+	github.com/creack/pty v1.1.18
+	golang.org/x/term v0.10.0
 	gopkg.in/fsnotify.v1 v1.4.7
+	mvdan.cc/sh/v3 v3.7.0
+)
+
+require (
+	github.com/fsnotify/fsnotify v1.4.7 // indirect
+	golang.org/x/sync v0.2.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
 )
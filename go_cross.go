@@ -0,0 +1,179 @@
+package gbtb
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/buildkite/interpolate"
+)
+
+// GoCrossBuild is a group of tasks building a Go package for a matrix of
+// platforms. Every platform is a target named after it's output file and
+// a target named Name builds all of them.
+type GoCrossBuild struct {
+	// Name of a target building all platforms
+	Name string
+	// Package built with `go build`
+	Package string
+	// Output is a template of an output file of a platform, it can reference
+	// ${GOOS}, ${GOARCH}, ${GOARM} and ${EXE}, which is .exe on windows.
+	// Defaults to Name-${GOOS}-${GOARCH}${GOARM}${EXE}.
+	Output string
+	// GOOS is a list of operating systems built
+	GOOS []string
+	// GOARCH is a list of architectures built for every GOOS
+	GOARCH []string
+	// GOARM is a list of ARM versions built for arm architecture
+	GOARM []string
+	// Exclude is a list of platforms in form of GOOS/GOARCH not built
+	Exclude []string
+	// CGOEnabled sets CGO_ENABLED=1, cross builds are built without cgo by default
+	CGOEnabled bool
+	// Flags are additional flags of `go build`
+	Flags []string
+	// Dependencies of every platform target, for example go.mod
+	Dependencies Dependencies
+	// PlatformDependencies returns dependencies of a platform target added to
+	// Dependencies, for example GoPackageDependencies of Package with GOOS and
+	// GOARCH of a platform set in Env, as files built differ between platforms
+	PlatformDependencies func(goos, goarch string) Dependencies
+	// Env is a list of environment variables set for `go build`, check out Task.Env
+	Env []string
+	// Dir is a working directory of `go build`
+	Dir string
+
+	lock    sync.Mutex
+	targets *MultiTargetTask
+	all     *Task
+}
+
+// goPlatform is a single platform of cross build matrix
+type goPlatform struct {
+	goos, goarch, goarm string
+}
+
+// env returns environment variables of `go build` for a platform
+func (p goPlatform) env(cgo bool) []string {
+	env := []string{"GOOS=" + p.goos, "GOARCH=" + p.goarch, "CGO_ENABLED=0"}
+	if p.goarm != "" {
+		env = append(env, "GOARM="+p.goarm)
+	}
+	if cgo {
+		env[2] = "CGO_ENABLED=1"
+	}
+	return env
+}
+
+// platforms returns platforms of a matrix by output files
+func (c *GoCrossBuild) platforms() (map[string]goPlatform, []string, error) {
+	output := c.Output
+	if output == "" {
+		output = c.Name + "-${GOOS}-${GOARCH}${GOARM}${EXE}"
+	}
+	excluded := make(map[string]bool)
+	for _, e := range c.Exclude {
+		excluded[e] = true
+	}
+	platforms := make(map[string]goPlatform)
+	var names []string
+	for _, goos := range c.GOOS {
+		for _, goarch := range c.GOARCH {
+			if excluded[goos+"/"+goarch] {
+				continue
+			}
+			arms := []string{""}
+			if goarch == "arm" && len(c.GOARM) > 0 {
+				arms = c.GOARM
+			}
+			for _, goarm := range arms {
+				p := goPlatform{goos: goos, goarch: goarch, goarm: goarm}
+				exe := ""
+				if goos == "windows" {
+					exe = ".exe"
+				}
+				name, err := interpolate.Interpolate(interpolate.NewSliceEnv([]string{
+					"GOOS=" + goos,
+					"GOARCH=" + goarch,
+					"GOARM=" + goarm,
+					"EXE=" + exe,
+				}), output)
+				if err != nil {
+					return nil, nil, err
+				}
+				if _, ok := platforms[name]; ok {
+					return nil, nil, fmt.Errorf("output %s of %s is not unique for every platform", output, c.Name)
+				}
+				platforms[name] = p
+				names = append(names, name)
+			}
+		}
+	}
+	return platforms, names, nil
+}
+
+func (c *GoCrossBuild) init() {
+	if c.all != nil {
+		return
+	}
+	platforms, names, err := c.platforms()
+	if err != nil {
+		c.targets = &MultiTargetTask{}
+		c.all = &Task{
+			Name: c.Name,
+			Job:  Job(func() error { return err }),
+		}
+		return
+	}
+	c.targets = &MultiTargetTask{
+		Names: names,
+		EnvJob: func(name string, env JobEnv) error {
+			output, err := filepath.Abs(name)
+			if err != nil {
+				return err
+			}
+			env.Env = append(append([]string{}, env.Env...), platforms[name].env(c.CGOEnabled)...)
+			return GoBuildEnv(c.Package, append(append([]string{}, c.Flags...), "-o", output)...)(env)
+		},
+		Env: c.Env,
+		Dir: c.Dir,
+	}
+	if c.Dependencies != nil || c.PlatformDependencies != nil {
+		c.targets.Dependencies = MultiTargetDependencyFunc(func(name string) Dependencies {
+			var deps DependenciesList
+			if c.Dependencies != nil {
+				deps = deps.Append(c.Dependencies)
+			}
+			if c.PlatformDependencies != nil {
+				p := platforms[name]
+				if d := c.PlatformDependencies(p.goos, p.goarch); d != nil {
+					deps = deps.Append(d)
+				}
+			}
+			return deps
+		})
+	}
+	c.all = &Task{
+		Name:         c.Name,
+		Dependencies: StaticDependencies(names),
+	}
+}
+
+// GetNames returns name of a target building all platforms and names of platform targets
+func (c *GoCrossBuild) GetNames() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.init()
+	return append([]string{c.Name}, c.targets.GetNames()...)
+}
+
+// GetTask for a name
+func (c *GoCrossBuild) GetTask(name string) TaskLike {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.init()
+	if name == c.Name {
+		return c.all
+	}
+	return c.targets.GetTask(name)
+}
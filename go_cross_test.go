@@ -0,0 +1,34 @@
+package gbtb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGoCrossBuildPlatformDependencies(t *testing.T) {
+	c := &GoCrossBuild{
+		Name:         "app",
+		GOOS:         []string{"linux", "windows"},
+		GOARCH:       []string{"amd64"},
+		Dependencies: StaticDependencies{"go.mod"},
+		PlatformDependencies: func(goos, goarch string) Dependencies {
+			return StaticDependencies{goos + "_" + goarch + ".go"}
+		},
+	}
+	for name, expected := range map[string][]string{
+		"app-linux-amd64":       {"go.mod", "linux_amd64.go"},
+		"app-windows-amd64.exe": {"go.mod", "windows_amd64.go"},
+	} {
+		task := c.GetTask(name)
+		if task == nil {
+			t.Fatalf("no target %s", name)
+		}
+		deps, err := task.DependsOn().Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(deps, expected) {
+			t.Errorf("%s depends on %v, expected %v", name, deps, expected)
+		}
+	}
+}
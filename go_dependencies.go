@@ -0,0 +1,251 @@
+package gbtb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// GoPackageDependencies is a dependency on files compiled into a Go package,
+// resolved with `go list -deps`. It includes source, cgo and embedded files
+// of a package and all non standard library packages it imports along with
+// go.mod and go.sum of their modules. Test files are not included.
+type GoPackageDependencies struct {
+	// Package is a package or pattern passed to `go list`
+	Package string
+	// Tags is a list of build tags
+	Tags []string
+	// Env is a list of environment variables in form of KEY=VALUE set for
+	// `go list`, for example GOOS and GOARCH of a build
+	Env []string
+	// Dir is a working directory of `go list`
+	Dir string
+}
+
+// goListError is an error of a package printed by `go list -e -json`
+type goListError struct {
+	Err string
+}
+
+// goListPackage is a package printed by `go list -json`
+type goListPackage struct {
+	Dir        string
+	Standard   bool
+	Error      *goListError
+	DepsErrors []*goListError
+	GoFiles    []string
+	CgoFiles   []string
+	CFiles     []string
+	CXXFiles   []string
+	MFiles     []string
+	HFiles     []string
+	FFiles     []string
+	SFiles     []string
+	SwigFiles  []string
+	SysoFiles  []string
+	EmbedFiles []string
+	Module     *struct {
+		Dir     string
+		GoMod   string
+		Main    bool
+		Replace *struct {
+			Path string
+		}
+	}
+}
+
+// local returns true if package is not in module cache
+func (p goListPackage) local() bool {
+	if p.Module == nil || p.Module.Main {
+		return true
+	}
+	r := p.Module.Replace
+	return r != nil && (strings.HasPrefix(r.Path, ".") || filepath.IsAbs(r.Path))
+}
+
+// goListResult is a memoized result of `go list`
+type goListResult struct {
+	pkgs []goListPackage
+	err  error
+}
+
+// goListCache memoizes results of `go list` while it's enabled, as both WatchDirs
+// and Get of GoPackageDependencies list the same packages
+var goListCache struct {
+	lock    sync.Mutex
+	users   int
+	results map[string]goListResult
+}
+
+// cacheGoList enables memoizing results of `go list` until returned function
+// is called. Files may change between builds, so it's enabled only for a single
+// evaluation of dependencies.
+func cacheGoList() func() {
+	goListCache.lock.Lock()
+	defer goListCache.lock.Unlock()
+	if goListCache.users == 0 {
+		goListCache.results = make(map[string]goListResult)
+	}
+	goListCache.users++
+	return func() {
+		goListCache.lock.Lock()
+		defer goListCache.lock.Unlock()
+		if goListCache.users--; goListCache.users == 0 {
+			goListCache.results = nil
+		}
+	}
+}
+
+// packages returns non standard library packages compiled into a package
+func (d GoPackageDependencies) packages() ([]goListPackage, error) {
+	args := []string{"list", "-e", "-deps", "-json"}
+	if len(d.Tags) > 0 {
+		args = append(args, "-tags", strings.Join(d.Tags, ","))
+	}
+	args = append(args, d.Package)
+	env, err := NewJobEnv(d.Package, d.Env, d.Dir)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.Join(append(append(append([]string{}, args...), env.Env...), env.Dir), "\x00")
+	goListCache.lock.Lock()
+	r, ok := goListCache.results[key]
+	goListCache.lock.Unlock()
+	if ok {
+		return r.pkgs, r.err
+	}
+	r.pkgs, r.err = goList(env, args)
+	goListCache.lock.Lock()
+	if goListCache.results != nil {
+		goListCache.results[key] = r
+	}
+	goListCache.lock.Unlock()
+	return r.pkgs, r.err
+}
+
+// goList runs `go list` with args and returns non standard library packages it
+// printed. Errors of packages, like missing imports, are returned as an error.
+func goList(env JobEnv, args []string) ([]goListPackage, error) {
+	out, err := env.OutputPipe(exec.Command("go", args...))
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []goListPackage
+	var errs []string
+	seen := make(map[string]struct{})
+	addErr := func(e *goListError) {
+		if e == nil || e.Err == "" {
+			return
+		}
+		if _, ok := seen[e.Err]; !ok {
+			seen[e.Err] = struct{}{}
+			errs = append(errs, e.Err)
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var pkg goListPackage
+		if err := dec.Decode(&pkg); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		addErr(pkg.Error)
+		for _, e := range pkg.DepsErrors {
+			addErr(e)
+		}
+		if !pkg.Standard {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("go list %s: %s", args[len(args)-1], strings.Join(errs, "; "))
+	}
+	return pkgs, nil
+}
+
+// relativePath returns path relative to working directory if it's inside of it
+func relativePath(wd, path string) string {
+	if wd == "" {
+		return path
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return rel
+}
+
+// Get returns files compiled into a package, files of packages in module cache
+// are not included as they never change
+func (d GoPackageDependencies) Get() ([]string, error) {
+	pkgs, err := d.packages()
+	if err != nil {
+		return nil, err
+	}
+	wd, _ := os.Getwd()
+	seen := make(map[string]struct{})
+	var deps []string
+	add := func(path string) {
+		path = relativePath(wd, path)
+		if _, ok := seen[path]; !ok {
+			seen[path] = struct{}{}
+			deps = append(deps, path)
+		}
+	}
+	for _, pkg := range pkgs {
+		if !pkg.local() {
+			continue
+		}
+		for _, files := range [][]string{
+			pkg.GoFiles, pkg.CgoFiles, pkg.CFiles, pkg.CXXFiles, pkg.MFiles, pkg.HFiles,
+			pkg.FFiles, pkg.SFiles, pkg.SwigFiles, pkg.SysoFiles, pkg.EmbedFiles,
+		} {
+			for _, f := range files {
+				add(filepath.Join(pkg.Dir, f))
+			}
+		}
+		if pkg.Module == nil {
+			continue
+		}
+		if pkg.Module.GoMod != "" {
+			add(pkg.Module.GoMod)
+		}
+		if pkg.Module.Dir != "" {
+			sum := filepath.Join(pkg.Module.Dir, "go.sum")
+			if _, err := os.Stat(sum); err == nil {
+				add(sum)
+			}
+		}
+	}
+	return deps, nil
+}
+
+// TargetDependencies returns the same dependencies for every target
+func (d GoPackageDependencies) TargetDependencies(string) Dependencies {
+	return d
+}
+
+// WatchDirs returns directories of packages compiled into a package, so that
+// dependencies are re-evaluated when files are added to them. Packages in
+// module cache are not watched.
+func (d GoPackageDependencies) WatchDirs() []string {
+	pkgs, err := d.packages()
+	if err != nil {
+		return nil
+	}
+	wd, _ := os.Getwd()
+	var dirs []string
+	for _, pkg := range pkgs {
+		if pkg.Dir != "" && pkg.local() {
+			dirs = append(dirs, relativePath(wd, pkg.Dir))
+		}
+	}
+	return dirs
+}
@@ -0,0 +1,65 @@
+package gbtb
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func writeTestModule(t *testing.T) {
+	writeTestFile(t, "go.mod", "module example.com/app\n\ngo 1.19\n", 0644)
+	writeTestFile(t, "main.go", "package main\n\nimport _ \"example.com/app/lib\"\n\nfunc main() {}\n", 0644)
+	writeTestFile(t, "lib/lib.go", "package lib\n", 0644)
+}
+
+func getSorted(t *testing.T, d Dependencies) []string {
+	deps, err := d.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+func TestGoPackageDependencies(t *testing.T) {
+	inTempDir(t)
+	writeTestModule(t)
+	d := GoPackageDependencies{Package: "."}
+	expected := []string{"go.mod", "lib/lib.go", "main.go"}
+	if deps := getSorted(t, d); !reflect.DeepEqual(deps, expected) {
+		t.Errorf("got dependencies %v, expected %v", deps, expected)
+	}
+	dirs := d.WatchDirs()
+	sort.Strings(dirs)
+	if expected := []string{".", "lib"}; !reflect.DeepEqual(dirs, expected) {
+		t.Errorf("got watched directories %v, expected %v", dirs, expected)
+	}
+}
+
+func TestGoPackageDependenciesMissingPackage(t *testing.T) {
+	inTempDir(t)
+	writeTestModule(t)
+	writeTestFile(t, "lib/lib.go", "package lib\n\nimport _ \"example.com/app/missing\"\n", 0644)
+	_, err := GoPackageDependencies{Package: ".", Env: []string{"GOPROXY=off"}}.Get()
+	if err == nil || !strings.Contains(err.Error(), "example.com/app/missing") {
+		t.Errorf("expected an error about missing package, got %v", err)
+	}
+}
+
+func TestGoPackageDependenciesCache(t *testing.T) {
+	inTempDir(t)
+	writeTestModule(t)
+	d := GoPackageDependencies{Package: "."}
+	release := cacheGoList()
+	before := getSorted(t, d)
+	writeTestFile(t, "lib/more.go", "package lib\n", 0644)
+	if deps := getSorted(t, d); !reflect.DeepEqual(deps, before) {
+		t.Errorf("go list was not cached, got %v, expected %v", deps, before)
+	}
+	release()
+	expected := []string{"go.mod", "lib/lib.go", "lib/more.go", "main.go"}
+	if deps := getSorted(t, d); !reflect.DeepEqual(deps, expected) {
+		t.Errorf("got dependencies %v after cache was released, expected %v", deps, expected)
+	}
+}
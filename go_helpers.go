@@ -1,10 +1,18 @@
 package gbtb
 
+import "os/exec"
+
 // Go is a simple job using go compiler
 func Go(subCommand string, args ...string) Job {
 	return func() error {
-		args = append([]string{subCommand}, args...)
-		return RunCommand("go", args...)
+		return GoEnv(subCommand, args...)(JobEnv{})
+	}
+}
+
+// GoEnv is a job using go compiler in task environment
+func GoEnv(subCommand string, args ...string) EnvJob {
+	return func(env JobEnv) error {
+		return env.PipeCommands(exec.Command("go", append([]string{subCommand}, args...)...))
 	}
 }
 
@@ -14,8 +22,20 @@ func GoBuild(pkg string, opts ...string) Job {
 	return Go("build", opts...)
 }
 
+// GoBuildEnv is a `go build` job ran in task environment
+func GoBuildEnv(pkg string, opts ...string) EnvJob {
+	opts = append(opts, pkg)
+	return GoEnv("build", opts...)
+}
+
 // GoRun is a `go run` job
 func GoRun(run []string, opts ...string) Job {
 	opts = append(opts, run...)
 	return Go("run", opts...)
 }
+
+// GoRunEnv is a `go run` job ran in task environment
+func GoRunEnv(run []string, opts ...string) EnvJob {
+	opts = append(opts, run...)
+	return GoEnv("run", opts...)
+}
@@ -0,0 +1,381 @@
+package gbtb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GoTestOptions configures reports of GoTest job
+type GoTestOptions struct {
+	// JUnit is a path of JUnit XML report written after tests finish
+	JUnit string
+	// Verbose prints output of passed tests as well, by default only output
+	// of failed tests is printed
+	Verbose bool
+}
+
+// GoTest is a `go test` job printing a summary of every package and output of
+// failed tests. It fails with a list of failed tests.
+func GoTest(pkg string, opts ...string) Job {
+	return GoTestOptions{}.GoTest(pkg, opts...)
+}
+
+// GoTestEnv is a `go test` job ran in task environment, check out GoTest
+func GoTestEnv(pkg string, opts ...string) EnvJob {
+	return GoTestOptions{}.GoTestEnv(pkg, opts...)
+}
+
+// GoTest is a `go test` job, check out GoTest
+func (o GoTestOptions) GoTest(pkg string, opts ...string) Job {
+	return func() error {
+		return o.GoTestEnv(pkg, opts...)(JobEnv{})
+	}
+}
+
+// GoTestEnv is a `go test` job ran in task environment, check out GoTest
+func (o GoTestOptions) GoTestEnv(pkg string, opts ...string) EnvJob {
+	return func(env JobEnv) error {
+		args := append(append([]string{"test", "-json"}, opts...), pkg)
+		pr, pw := io.Pipe()
+		report := newGoTestReport()
+		parsed := make(chan struct{})
+		go func() {
+			defer close(parsed)
+			report.parse(pr, o.Verbose)
+		}()
+		cmd := exec.Command("go", args...)
+		cmd.Stdout = pw
+		err := env.PipeCommands(cmd)
+		pw.Close()
+		<-parsed
+		fmt.Fprint(stdout, report.total())
+		if o.JUnit != "" {
+			if jerr := report.writeJUnit(o.JUnit); jerr != nil {
+				return fmt.Errorf("could not write JUnit report %s: %v", o.JUnit, jerr)
+			}
+		}
+		if failed := report.failed(); len(failed) > 0 {
+			return fmt.Errorf("tests failed: %s", strings.Join(failed, ", "))
+		}
+		return err
+	}
+}
+
+// goTestEvent is an event emitted by `go test -json`
+type goTestEvent struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+type goTestResult struct {
+	name    string
+	action  string
+	elapsed float64
+	output  bytes.Buffer
+}
+
+type goTestPackage struct {
+	name   string
+	start  time.Time
+	result goTestResult
+	tests  []*goTestResult
+	byName map[string]*goTestResult
+}
+
+// test returns result of test named name, creating it on first use
+func (p *goTestPackage) test(name string) *goTestResult {
+	t, ok := p.byName[name]
+	if !ok {
+		t = &goTestResult{name: name}
+		p.byName[name] = t
+		p.tests = append(p.tests, t)
+	}
+	return t
+}
+
+// failedTests returns failed tests of a package, a test is failed if it
+// failed or did not finish in failed package. Tests failed only because
+// their subtests failed are left out.
+func (p *goTestPackage) failedTests() []*goTestResult {
+	var failed []*goTestResult
+	for _, t := range p.tests {
+		if t.action == "fail" || (t.action == "" && p.result.action == "fail") {
+			failed = append(failed, t)
+		}
+	}
+	var leaves []*goTestResult
+	for _, t := range failed {
+		leaf := true
+		for _, sub := range failed {
+			if strings.HasPrefix(sub.name, t.name+"/") {
+				leaf = false
+				break
+			}
+		}
+		if leaf {
+			leaves = append(leaves, t)
+		}
+	}
+	return leaves
+}
+
+// count returns number of tests with action
+func (p *goTestPackage) count(action string) int {
+	n := 0
+	for _, t := range p.tests {
+		if t.action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// summary returns a summary of finished package with output of failed tests
+func (p *goTestPackage) summary(verbose bool) string {
+	var b strings.Builder
+	status := "ok"
+	switch p.result.action {
+	case "fail":
+		status = "FAIL"
+	case "skip":
+		status = "?"
+	}
+	fmt.Fprintf(&b, "%-4s %s", status, p.name)
+	if p.result.action == "skip" {
+		b.WriteString(" [no test files]\n")
+		return b.String()
+	}
+	fmt.Fprintf(&b, " %.3fs (%d passed, %d failed, %d skipped)\n", p.result.elapsed, p.count("pass"), len(p.failedTests()), p.count("skip"))
+	if verbose {
+		for _, t := range p.tests {
+			if t.action == "pass" {
+				b.Write(t.output.Bytes())
+			}
+		}
+	}
+	failed := p.failedTests()
+	for _, t := range failed {
+		b.Write(t.output.Bytes())
+	}
+	if p.result.action == "fail" && len(failed) == 0 {
+		// package failed without failed tests, build failed or it panicked
+		b.Write(p.result.output.Bytes())
+	}
+	return b.String()
+}
+
+// goTestReport collects results of `go test -json`
+type goTestReport struct {
+	packages []*goTestPackage
+	byName   map[string]*goTestPackage
+}
+
+func newGoTestReport() *goTestReport {
+	return &goTestReport{byName: make(map[string]*goTestPackage)}
+}
+
+func (r *goTestReport) pkg(name string, start time.Time) *goTestPackage {
+	p, ok := r.byName[name]
+	if !ok {
+		p = &goTestPackage{
+			name:   name,
+			start:  start,
+			byName: make(map[string]*goTestResult),
+		}
+		r.byName[name] = p
+		r.packages = append(r.packages, p)
+	}
+	return p
+}
+
+// parse reads events from in, printing summary of every package once it finishes.
+// Lines that are not events are printed as they are.
+func (r *goTestReport) parse(in io.Reader, verbose bool) {
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var ev goTestEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil || ev.Action == "" {
+			fmt.Fprintln(stdout, sc.Text())
+			continue
+		}
+		if ev.Package == "" {
+			if ev.Output != "" {
+				fmt.Fprint(stdout, ev.Output)
+			}
+			continue
+		}
+		p := r.pkg(ev.Package, ev.Time)
+		res := &p.result
+		if ev.Test != "" {
+			res = p.test(ev.Test)
+		}
+		switch ev.Action {
+		case "output":
+			res.output.WriteString(ev.Output)
+		case "pass", "fail", "skip":
+			res.action = ev.Action
+			res.elapsed = ev.Elapsed
+			if ev.Test == "" {
+				fmt.Fprint(stdout, p.summary(verbose))
+			}
+		}
+	}
+	// drain the rest so that go test is not blocked
+	io.Copy(ioutil.Discard, in)
+}
+
+// failed returns names of failed tests in form of package.Test, packages
+// that failed without failed tests are returned by their name
+func (r *goTestReport) failed() []string {
+	var names []string
+	for _, p := range r.packages {
+		failed := p.failedTests()
+		for _, t := range failed {
+			names = append(names, p.name+"."+t.name)
+		}
+		if p.result.action == "fail" && len(failed) == 0 {
+			names = append(names, p.name)
+		}
+	}
+	return names
+}
+
+// total returns a summary of all packages
+func (r *goTestReport) total() string {
+	var passed, failed, skipped int
+	for _, p := range r.packages {
+		passed += p.count("pass")
+		failed += len(p.failedTests())
+		skipped += p.count("skip")
+	}
+	return fmt.Sprintf("%d packages, %d tests passed, %d failed, %d skipped\n", len(r.packages), passed, failed, skipped)
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Classname string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut *junitOutput  `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",cdata"`
+}
+
+type junitOutput struct {
+	Text string `xml:",cdata"`
+}
+
+func junitTime(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
+
+// junit returns report in JUnit XML format, every package is a test suite
+func (r *goTestReport) junit() junitTestSuites {
+	var suites junitTestSuites
+	var elapsed float64
+	for _, p := range r.packages {
+		suite := junitTestSuite{
+			Name: p.name,
+			Time: junitTime(p.result.elapsed),
+		}
+		if !p.start.IsZero() {
+			suite.Timestamp = p.start.Format(time.RFC3339)
+		}
+		failed := make(map[*goTestResult]bool)
+		for _, t := range p.failedTests() {
+			failed[t] = true
+		}
+		for _, t := range p.tests {
+			c := junitTestCase{
+				Classname: p.name,
+				Name:      t.name,
+				Time:      junitTime(t.elapsed),
+			}
+			switch {
+			case failed[t]:
+				c.Failure = &junitMessage{Message: "Failed", Text: t.output.String()}
+				suite.Failures++
+			case t.action == "skip":
+				c.Skipped = &junitMessage{Message: "Skipped", Text: t.output.String()}
+				suite.Skipped++
+			case t.action == "pass":
+				c.SystemOut = &junitOutput{Text: t.output.String()}
+			default:
+				// parent of failed subtests is reported by it's subtests
+				continue
+			}
+			suite.Cases = append(suite.Cases, c)
+		}
+		if p.result.action == "fail" && len(failed) == 0 {
+			suite.Cases = append(suite.Cases, junitTestCase{
+				Classname: p.name,
+				Name:      "[build failed]",
+				Time:      junitTime(p.result.elapsed),
+				Failure:   &junitMessage{Message: "Failed", Text: p.result.output.String()},
+			})
+			suite.Failures++
+		}
+		suite.Tests = len(suite.Cases)
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+		elapsed += p.result.elapsed
+		suites.Suites = append(suites.Suites, suite)
+	}
+	sort.SliceStable(suites.Suites, func(i, j int) bool {
+		return suites.Suites[i].Name < suites.Suites[j].Name
+	})
+	suites.Time = junitTime(elapsed)
+	return suites
+}
+
+// writeJUnit writes report in JUnit XML format to path
+func (r *goTestReport) writeJUnit(path string) error {
+	b, err := xml.MarshalIndent(r.junit(), "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), append(b, '\n')...), 0644)
+}
@@ -0,0 +1,315 @@
+package gbtb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// HTTPCache is a remote cache speaking the simple HTTP protocol of bazel-remote
+// and Bazel's --remote_cache=http://... option.
+// Action results are stored with GET/PUT on URL/ac/<key> as protobuf encoded
+// build.bazel.remote.execution.v2.ActionResult messages and output contents
+// with GET/PUT on URL/cas/<sha256>.
+type HTTPCache struct {
+	// URL is a base url of a cache, for example http://localhost:8080
+	URL string
+	// Client used for requests, http.DefaultClient is used if nil
+	Client *http.Client
+	// ReadOnly cache only restores outputs and never uploads them. Useful
+	// for developer machines sharing outputs uploaded by CI.
+	ReadOnly bool
+}
+
+var errHTTPCacheMiss = errors.New("cache miss")
+
+func (h *HTTPCache) client() *http.Client {
+	if h.Client != nil {
+		return h.Client
+	}
+	return http.DefaultClient
+}
+
+func (h *HTTPCache) url(kind, hash string) string {
+	return strings.TrimSuffix(h.URL, "/") + "/" + kind + "/" + hash
+}
+
+func (h *HTTPCache) get(kind, hash string) (io.ReadCloser, error) {
+	resp, err := h.client().Get(h.url(kind, hash))
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, errHTTPCacheMiss
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("GET %s: %s", h.url(kind, hash), resp.Status)
+}
+
+func (h *HTTPCache) put(kind, hash string, body io.Reader, size int64) error {
+	req, err := http.NewRequest(http.MethodPut, h.url(kind, hash), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := h.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("PUT %s: %s", h.url(kind, hash), resp.Status)
+	}
+	return nil
+}
+
+// Restore outputs for key from remote cache
+func (h *HTTPCache) Restore(key string, outputs []string) (bool, error) {
+	body, err := h.get("ac", key)
+	if err == errHTTPCacheMiss {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	b, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return false, err
+	}
+	entry, err := decodeActionResult(b)
+	if err != nil {
+		return false, err
+	}
+	selected, ok := entry.selectOutputs(outputs)
+	if !ok {
+		return false, nil
+	}
+	// download all blobs before touching any output
+	temps := make([]string, len(selected))
+	defer func() {
+		for _, name := range temps {
+			if name != "" {
+				os.Remove(name)
+			}
+		}
+	}()
+	for i, o := range selected {
+		temps[i], err = h.fetchBlob(o)
+		if err == errHTTPCacheMiss {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	for i, o := range selected {
+		if err := os.Rename(temps[i], o.Path); err != nil {
+			return false, err
+		}
+		temps[i] = ""
+	}
+	return true, nil
+}
+
+// fetchBlob downloads content of an output to a temporary file next to it,
+// verified against it's hash, and returns name of the file
+func (h *HTTPCache) fetchBlob(o cacheEntryOutput) (string, error) {
+	body, err := h.get("cas", o.Hash)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	sum := sha256.New()
+	name, err := writeTempFile(o.Path, io.TeeReader(body, sum), o.Mode)
+	if err != nil {
+		return "", err
+	}
+	if hex.EncodeToString(sum.Sum(nil)) != o.Hash {
+		os.Remove(name)
+		return "", fmt.Errorf("blob %s for %s has invalid hash", o.Hash, o.Path)
+	}
+	return name, nil
+}
+
+// Store outputs in remote cache under key, does nothing if cache is ReadOnly
+func (h *HTTPCache) Store(key string, outputs []string) error {
+	if h.ReadOnly {
+		return nil
+	}
+	var entry cacheEntry
+	for _, o := range outputs {
+		st, err := os.Stat(o)
+		if err != nil {
+			return err
+		}
+		if !st.Mode().IsRegular() {
+			return fmt.Errorf("output %s is not a regular file", o)
+		}
+		sum, err := fileSum(o)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(o)
+		if err != nil {
+			return err
+		}
+		err = h.put("cas", sum, f, st.Size())
+		f.Close()
+		if err != nil {
+			return err
+		}
+		entry.Outputs = append(entry.Outputs, cacheEntryOutput{
+			Path: o,
+			Hash: sum,
+			Size: st.Size(),
+			Mode: st.Mode().Perm(),
+		})
+	}
+	b := encodeActionResult(entry)
+	return h.put("ac", key, bytes.NewReader(b), int64(len(b)))
+}
+
+// Minimal protobuf wire format encoding of ActionResult, only output files
+// are supported:
+//
+//	message ActionResult { repeated OutputFile output_files = 2; }
+//	message OutputFile { string path = 1; Digest digest = 2; bool is_executable = 4; }
+//	message Digest { string hash = 1; int64 size_bytes = 2; }
+const (
+	pbVarint = 0
+	pbI64    = 1
+	pbBytes  = 2
+	pbI32    = 5
+)
+
+func pbAppendTag(b []byte, field, wire int) []byte {
+	return pbAppendVarint(b, uint64(field<<3|wire))
+}
+
+func pbAppendVarint(b []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(b, tmp[:n]...)
+}
+
+func pbAppendBytes(b []byte, field int, v []byte) []byte {
+	b = pbAppendTag(b, field, pbBytes)
+	b = pbAppendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func encodeActionResult(entry cacheEntry) []byte {
+	var b []byte
+	for _, o := range entry.Outputs {
+		var digest []byte
+		digest = pbAppendBytes(digest, 1, []byte(o.Hash))
+		digest = pbAppendTag(digest, 2, pbVarint)
+		digest = pbAppendVarint(digest, uint64(o.Size))
+		var file []byte
+		file = pbAppendBytes(file, 1, []byte(o.Path))
+		file = pbAppendBytes(file, 2, digest)
+		if o.Mode&0111 != 0 {
+			file = pbAppendTag(file, 4, pbVarint)
+			file = pbAppendVarint(file, 1)
+		}
+		b = pbAppendBytes(b, 2, file)
+	}
+	return b
+}
+
+// pbFields calls f for each field in a message, deprecated groups are not supported
+func pbFields(b []byte, f func(field, wire int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("invalid protobuf tag")
+		}
+		b = b[n:]
+		field, wire := int(tag>>3), int(tag&7)
+		var v uint64
+		var data []byte
+		switch wire {
+		case pbVarint:
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return fmt.Errorf("invalid protobuf varint")
+			}
+			b = b[n:]
+		case pbI64:
+			if len(b) < 8 {
+				return fmt.Errorf("invalid protobuf fixed64")
+			}
+			b = b[8:]
+		case pbI32:
+			if len(b) < 4 {
+				return fmt.Errorf("invalid protobuf fixed32")
+			}
+			b = b[4:]
+		case pbBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return fmt.Errorf("invalid protobuf length")
+			}
+			data = b[n : n+int(l)]
+			b = b[n+int(l):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wire)
+		}
+		if err := f(field, wire, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeActionResult(b []byte) (entry cacheEntry, err error) {
+	err = pbFields(b, func(field, wire int, _ uint64, data []byte) error {
+		if field != 2 || wire != pbBytes {
+			return nil
+		}
+		o := cacheEntryOutput{Mode: 0644}
+		err := pbFields(data, func(field, wire int, v uint64, data []byte) error {
+			switch {
+			case field == 1 && wire == pbBytes:
+				o.Path = string(data)
+			case field == 2 && wire == pbBytes:
+				return pbFields(data, func(field, wire int, v uint64, data []byte) error {
+					switch {
+					case field == 1 && wire == pbBytes:
+						o.Hash = string(data)
+					case field == 2 && wire == pbVarint:
+						o.Size = int64(v)
+					}
+					return nil
+				})
+			case field == 4 && wire == pbVarint && v != 0:
+				o.Mode = 0755
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if o.Path == "" || len(o.Hash) != sha256.Size*2 {
+			return fmt.Errorf("invalid output file in action result")
+		}
+		entry.Outputs = append(entry.Outputs, o)
+		return nil
+	})
+	return
+}
@@ -0,0 +1,336 @@
+package gbtb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRemoteCache is an in memory stand-in of bazel-remote HTTP cache
+type fakeRemoteCache struct {
+	lock  sync.Mutex
+	blobs map[string][]byte
+}
+
+func newFakeRemoteCache(t *testing.T) (*fakeRemoteCache, *HTTPCache) {
+	f := &fakeRemoteCache{blobs: make(map[string][]byte)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, &HTTPCache{URL: srv.URL, Client: srv.Client()}
+}
+
+func (f *fakeRemoteCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/ac/") && !strings.HasPrefix(r.URL.Path, "/cas/") {
+		http.NotFound(w, r)
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		b, ok := f.blobs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	case http.MethodPut:
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.blobs[r.URL.Path] = b
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeRemoteCache) set(path string, b []byte) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.blobs[path] = b
+}
+
+func (f *fakeRemoteCache) remove(path string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.blobs, path)
+}
+
+// inTempDir runs test in a temporary working directory
+func inTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "gbtb-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+func writeTestFile(t *testing.T, path, content string, mode os.FileMode) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestHTTPCacheRoundTrip(t *testing.T) {
+	inTempDir(t)
+	_, cache := newFakeRemoteCache(t)
+	writeTestFile(t, "bin/app", "binary", 0755)
+	writeTestFile(t, "out.txt", "text", 0644)
+	outputs := []string{"bin/app", "out.txt"}
+	if err := cache.Store("key", outputs); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll("bin")
+	writeTestFile(t, "out.txt", "changed", 0644)
+	hit, err := cache.Restore("key", outputs)
+	if err != nil || !hit {
+		t.Fatalf("expected hit, got %v, %v", hit, err)
+	}
+	if got := readTestFile(t, "bin/app"); got != "binary" {
+		t.Errorf("bin/app restored as %q", got)
+	}
+	if got := readTestFile(t, "out.txt"); got != "text" {
+		t.Errorf("out.txt restored as %q", got)
+	}
+	st, err := os.Stat("bin/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm()&0100 == 0 {
+		t.Errorf("bin/app restored without executable bit: %v", st.Mode())
+	}
+}
+
+func TestHTTPCacheMiss(t *testing.T) {
+	inTempDir(t)
+	_, cache := newFakeRemoteCache(t)
+	hit, err := cache.Restore("missing", []string{"out.txt"})
+	if err != nil || hit {
+		t.Fatalf("expected miss, got %v, %v", hit, err)
+	}
+}
+
+func TestHTTPCacheMissingRequestedOutput(t *testing.T) {
+	inTempDir(t)
+	_, cache := newFakeRemoteCache(t)
+	writeTestFile(t, "a.txt", "a", 0644)
+	if err := cache.Store("key", []string{"a.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	hit, err := cache.Restore("key", []string{"a.txt", "b.txt"})
+	if err != nil || hit {
+		t.Fatalf("expected miss, got %v, %v", hit, err)
+	}
+}
+
+func TestHTTPCachePartialMiss(t *testing.T) {
+	inTempDir(t)
+	remote, cache := newFakeRemoteCache(t)
+	writeTestFile(t, "a.txt", "a", 0644)
+	writeTestFile(t, "b.txt", "b", 0644)
+	outputs := []string{"a.txt", "b.txt"}
+	if err := cache.Store("key", outputs); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := fileSum("b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote.remove("/cas/" + sum)
+	writeTestFile(t, "a.txt", "old a", 0644)
+	writeTestFile(t, "b.txt", "old b", 0644)
+	hit, err := cache.Restore("key", outputs)
+	if err != nil || hit {
+		t.Fatalf("expected miss, got %v, %v", hit, err)
+	}
+	if got := readTestFile(t, "a.txt"); got != "old a" {
+		t.Errorf("a.txt was overwritten with %q on a miss", got)
+	}
+	if got := readTestFile(t, "b.txt"); got != "old b" {
+		t.Errorf("b.txt was overwritten with %q on a miss", got)
+	}
+}
+
+func TestHTTPCacheWritesOnlyRequestedPaths(t *testing.T) {
+	dir := inTempDir(t)
+	if err := os.Mkdir("work", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("work"); err != nil {
+		t.Fatal(err)
+	}
+	remote, cache := newFakeRemoteCache(t)
+	content := []byte("evil")
+	sum, err := fileSumOf(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote.set("/cas/"+sum, content)
+	remote.set("/ac/key", encodeActionResult(cacheEntry{Outputs: []cacheEntryOutput{
+		{Path: "../evil", Hash: sum, Size: int64(len(content)), Mode: 0644},
+	}}))
+	hit, err := cache.Restore("key", []string{"out.txt"})
+	if err != nil || hit {
+		t.Fatalf("expected miss for output not requested, got %v, %v", hit, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil")); !os.IsNotExist(err) {
+		t.Errorf("output not requested was written: %v", err)
+	}
+}
+
+func TestHTTPCacheRestoresOutputsOutsideWorkingDirectory(t *testing.T) {
+	dir := inTempDir(t)
+	if err := os.Mkdir("work", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("work"); err != nil {
+		t.Fatal(err)
+	}
+	_, cache := newFakeRemoteCache(t)
+	outputs := []string{"../dist/app", filepath.Join(dir, "abs.txt")}
+	writeTestFile(t, outputs[0], "app", 0755)
+	writeTestFile(t, outputs[1], "abs", 0644)
+	if err := cache.Store("key", outputs); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(filepath.Join(dir, "dist"))
+	os.Remove(outputs[1])
+	hit, err := cache.Restore("key", outputs)
+	if err != nil || !hit {
+		t.Fatalf("expected hit, got %v, %v", hit, err)
+	}
+	if got := readTestFile(t, outputs[0]); got != "app" {
+		t.Errorf("%s restored as %q", outputs[0], got)
+	}
+	if got := readTestFile(t, outputs[1]); got != "abs" {
+		t.Errorf("%s restored as %q", outputs[1], got)
+	}
+}
+
+func TestHTTPCacheInvalidBlobLeavesNoFiles(t *testing.T) {
+	inTempDir(t)
+	remote, cache := newFakeRemoteCache(t)
+	writeTestFile(t, "a.txt", "a", 0644)
+	writeTestFile(t, "b.txt", "b", 0644)
+	outputs := []string{"a.txt", "b.txt"}
+	if err := cache.Store("key", outputs); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := fileSum("b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote.set("/cas/"+sum, []byte("corrupted"))
+	writeTestFile(t, "a.txt", "old a", 0644)
+	if hit, err := cache.Restore("key", outputs); err == nil || hit {
+		t.Fatalf("expected error for invalid blob, got %v, %v", hit, err)
+	}
+	if got := readTestFile(t, "a.txt"); got != "old a" {
+		t.Errorf("a.txt was overwritten with %q on error", got)
+	}
+	files, err := filepath.Glob(".gbtb-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("temporary files left behind: %v", files)
+	}
+}
+
+func fileSumOf(b []byte) (string, error) {
+	f, err := ioutil.TempFile("", "gbtb-sum-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		return "", err
+	}
+	return fileSum(f.Name())
+}
+
+func TestActionResultEncoding(t *testing.T) {
+	entry := cacheEntry{Outputs: []cacheEntryOutput{
+		{Path: "bin/app", Hash: strings.Repeat("ab", 32), Size: 1 << 40, Mode: 0755},
+		{Path: "out.txt", Hash: strings.Repeat("01", 32), Size: 0, Mode: 0644},
+	}}
+	got, err := decodeActionResult(encodeActionResult(entry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Outputs) != len(entry.Outputs) {
+		t.Fatalf("decoded %d outputs, expected %d", len(got.Outputs), len(entry.Outputs))
+	}
+	for i, o := range got.Outputs {
+		if o != entry.Outputs[i] {
+			t.Errorf("output %d decoded as %+v, expected %+v", i, o, entry.Outputs[i])
+		}
+	}
+}
+
+func TestActionResultDecodingSkipsUnknownFields(t *testing.T) {
+	var b []byte
+	// exit_code = 4, stdout_raw = 5 and a fixed64 field
+	b = pbAppendTag(b, 4, pbVarint)
+	b = pbAppendVarint(b, 1)
+	b = pbAppendBytes(b, 5, []byte("stdout"))
+	b = pbAppendTag(b, 15, pbI64)
+	b = append(b, make([]byte, 8)...)
+	b = append(b, encodeActionResult(cacheEntry{Outputs: []cacheEntryOutput{
+		{Path: "out.txt", Hash: strings.Repeat("01", 32), Size: 3, Mode: 0644},
+	}})...)
+	got, err := decodeActionResult(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Outputs) != 1 || got.Outputs[0].Path != "out.txt" {
+		t.Errorf("unexpected outputs %+v", got.Outputs)
+	}
+}
+
+func TestActionResultDecodingErrors(t *testing.T) {
+	valid := encodeActionResult(cacheEntry{Outputs: []cacheEntryOutput{
+		{Path: "out.txt", Hash: strings.Repeat("01", 32), Size: 3, Mode: 0644},
+	}})
+	for name, b := range map[string][]byte{
+		"truncated":    valid[:len(valid)-1],
+		"invalid hash": encodeActionResult(cacheEntry{Outputs: []cacheEntryOutput{{Path: "out.txt", Hash: "abc"}}}),
+		"empty path":   encodeActionResult(cacheEntry{Outputs: []cacheEntryOutput{{Hash: strings.Repeat("01", 32)}}}),
+		"bad varint":   bytes.Repeat([]byte{0xff}, 11),
+	} {
+		if _, err := decodeActionResult(b); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package gbtb
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar"
+)
+
+type ignorePattern struct {
+	pattern string
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreMatcher matches paths relative to working directory against
+// a list of patterns with .gitignore semantics. Last matching pattern wins.
+type ignoreMatcher []ignorePattern
+
+func parseIgnorePattern(p string) (ignorePattern, bool) {
+	p = strings.TrimRight(p, " \t\r")
+	if p == "" || strings.HasPrefix(p, "#") {
+		return ignorePattern{}, false
+	}
+	var ip ignorePattern
+	if strings.HasPrefix(p, "!") {
+		ip.negate, p = true, p[1:]
+	}
+	if strings.HasSuffix(p, "/") {
+		ip.dirOnly, p = true, strings.TrimRight(p, "/")
+	}
+	switch {
+	case strings.HasPrefix(p, "/"):
+		p = p[1:]
+	case !strings.Contains(p, "/"):
+		// patterns without slash match at any level
+		p = "**/" + p
+	}
+	ip.pattern = p
+	return ip, p != ""
+}
+
+func newIgnoreMatcher(patterns []string) ignoreMatcher {
+	var m ignoreMatcher
+	for _, p := range patterns {
+		if ip, ok := parseIgnorePattern(p); ok {
+			m = append(m, ip)
+		}
+	}
+	return m
+}
+
+// loadGitIgnore appends patterns from .gitignore file in working directory
+func (m ignoreMatcher) loadGitIgnore() (ignoreMatcher, error) {
+	f, err := os.Open(".gitignore")
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// .git directory is never interesting
+	m = append(m, ignorePattern{pattern: "**/.git", dirOnly: true})
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if ip, ok := parseIgnorePattern(sc.Text()); ok {
+			m = append(m, ip)
+		}
+	}
+	return m, sc.Err()
+}
+
+func (m ignoreMatcher) matchOne(path string, dir bool) (ignored bool) {
+	for _, p := range m {
+		if p.dirOnly && !dir {
+			continue
+		}
+		if ok, _ := doublestar.Match(p.pattern, path); ok {
+			ignored = !p.negate
+		}
+	}
+	return
+}
+
+// Match returns true if path or any of it's parent directories is ignored
+func (m ignoreMatcher) Match(path string) bool {
+	if len(m) == 0 {
+		return false
+	}
+	if filepath.IsAbs(path) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return false
+		}
+		if path, err = filepath.Rel(wd, path); err != nil {
+			return false
+		}
+	}
+	path = filepath.ToSlash(filepath.Clean(path))
+	if path == "." || strings.HasPrefix(path, "../") {
+		return false
+	}
+	components := strings.Split(path, "/")
+	for i := range components {
+		prefix := strings.Join(components[:i+1], "/")
+		dir := i < len(components)-1
+		if !dir {
+			if st, err := os.Stat(path); err == nil && st.IsDir() {
+				dir = true
+			}
+		}
+		if m.matchOne(prefix, dir) {
+			return true
+		}
+	}
+	return false
+}
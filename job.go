@@ -1,10 +1,99 @@
 package gbtb
 
-import "os/exec"
+import (
+	"os/exec"
+
+	"github.com/buildkite/interpolate"
+)
+
+// TaskNameEnv is a name of environment variable holding a name of task
+// running a job
+const TaskNameEnv = "TASK_NAME"
+
+// JobEnv is an environment in which task runs it's job
+type JobEnv struct {
+	// Name of a task running a job
+	Name string
+	// Env is a list of environment variables in form of KEY=VALUE added
+	// to commands ran by job
+	Env []string
+	// Dir is a working directory of commands ran by job
+	Dir string
+	// Pty runs the last command of a pipeline under pseudo-terminal, check out Pty
+	Pty bool
+}
+
+// NewJobEnv returns an environment of a task named name. Values of env and dir
+// are interpolated using process environment, task name exposed as TASK_NAME
+// and variables preceding them in env.
+func NewJobEnv(name string, env []string, dir string) (JobEnv, error) {
+	je := JobEnv{
+		Name: name,
+		Env:  []string{TaskNameEnv + "=" + name},
+	}
+	for _, e := range env {
+		v, err := interpolate.Interpolate(je.interpolateEnv(), e)
+		if err != nil {
+			return JobEnv{}, err
+		}
+		je.Env = append(je.Env, v)
+	}
+	var err error
+	je.Dir, err = interpolate.Interpolate(je.interpolateEnv(), dir)
+	if err != nil {
+		return JobEnv{}, err
+	}
+	return je, nil
+}
+
+func (e JobEnv) interpolateEnv() interpolate.Env {
+	cmd := exec.Cmd{Env: append([]string{}, e.Env...)}
+	addEnv(&cmd)
+	return interpolate.NewSliceEnv(cmd.Env)
+}
+
+// Apply adds job environment to commands. Variables already set in command
+// take precedence over job variables, working directory is only set
+// if command does not have one.
+func (e JobEnv) Apply(cmds ...*exec.Cmd) {
+	for _, cmd := range cmds {
+		cmd.Env = append(append([]string{}, e.Env...), cmd.Env...)
+		if cmd.Dir == "" {
+			cmd.Dir = e.Dir
+		}
+	}
+}
+
+// Command returns a command with job environment applied
+func (e JobEnv) Command(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	e.Apply(cmd)
+	return cmd
+}
+
+// PipeCommands runs commands in job environment, check out PipeCommands
+func (e JobEnv) PipeCommands(cmds ...*exec.Cmd) error {
+	e.Apply(cmds...)
+	return runCommands(e.Pty || Pty, cmds...)
+}
+
+// OutputPipe runs commands in job environment, check out OutputPipe
+func (e JobEnv) OutputPipe(cmds ...*exec.Cmd) ([]byte, error) {
+	e.Apply(cmds...)
+	return OutputPipe(cmds...)
+}
+
+// Output runs a command in job environment and returns it's stdout
+func (e JobEnv) Output(cmd string, args ...string) ([]byte, error) {
+	return e.OutputPipe(exec.Command(cmd, args...))
+}
 
 // Job is an operation in build
 type Job func() error
 
+// EnvJob is an operation in build ran in task environment, check out Task.EnvJob
+type EnvJob func(JobEnv) error
+
 // CommandJob is a convienience function that simply runs a command as a job
 func CommandJob(cmd string, args ...string) Job {
 	return CommandJobPipe(exec.Command(cmd, args...))
@@ -17,10 +106,26 @@ func CommandJobPipe(cmds ...*exec.Cmd) Job {
 	}
 }
 
+// CommandJobEnv runs a command as a job in task environment
+func CommandJobEnv(cmd string, args ...string) EnvJob {
+	return CommandJobPipeEnv(exec.Command(cmd, args...))
+}
+
+// CommandJobPipeEnv runs a pipe of commands as a job in task environment
+func CommandJobPipeEnv(cmds ...*exec.Cmd) EnvJob {
+	return func(env JobEnv) error {
+		return env.PipeCommands(cmds...)
+	}
+}
+
 // MultiTargetJob for a multitarget task
 type MultiTargetJob func(string) error
 
-// StoppableCommandJob is a convienience function that runs a stoppable job
+// MultiTargetEnvJob for a multitarget task ran in task environment
+type MultiTargetEnvJob func(string, JobEnv) error
+
+// StoppableCommandJob is a convienience function that runs a stoppable job,
+// check out StopOptions.StoppableCommandJob to configure how it's stopped
 func StoppableCommandJob(cmd string, args ...string) func(chan struct{}) error {
 	return func(stop chan struct{}) error {
 		return RunStoppable(stop, cmd, args...)
@@ -0,0 +1,131 @@
+package gbtb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Live reload events
+const (
+	// LiveReloadRebuilt is sent after a successful rebuild
+	LiveReloadRebuilt = "rebuilt"
+	// LiveReloadFailed is sent after a failed rebuild along with an error
+	LiveReloadFailed = "build failed"
+)
+
+// liveReloadScript reloads page on rebuild and logs build failures,
+// events are read from the server script was loaded from
+const liveReloadScript = `(function() {
+	var src = document.currentScript ? document.currentScript.src : "/livereload.js";
+	var events = new EventSource(src.replace(/livereload\.js.*$/, "livereload"));
+	events.addEventListener("rebuilt", function() {
+		location.reload();
+	});
+	events.addEventListener("build failed", function(e) {
+		console.error("build failed: " + JSON.parse(e.data).error);
+	});
+})();
+`
+
+// LiveReloadSnippet returns a script tag to include in development pages,
+// addr is an address of live reload server as seen by a browser
+func LiveReloadSnippet(addr string) string {
+	return fmt.Sprintf(`<script src="http://%s/livereload.js"></script>`, addr)
+}
+
+// LiveReloadEvent is sent to browsers after every rebuild
+type LiveReloadEvent struct {
+	Event string `json:"event"`
+	Error string `json:"error,omitempty"`
+}
+
+// liveReload is a server sending rebuild events to browsers with Server-Sent Events
+// on /livereload, script handling them is served on /livereload.js
+type liveReload struct {
+	server *http.Server
+
+	lock    sync.Mutex
+	clients map[chan LiveReloadEvent]struct{}
+}
+
+func newLiveReload(addr string) (*liveReload, error) {
+	l := &liveReload{clients: make(map[chan LiveReloadEvent]struct{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livereload", l.events)
+	mux.HandleFunc("/livereload.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript")
+		fmt.Fprint(w, liveReloadScript)
+	})
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l.server = &http.Server{Handler: mux}
+	go l.server.Serve(listener)
+	fmt.Printf("live reload listening on %s\n", listener.Addr())
+	return l, nil
+}
+
+func (l *liveReload) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	events := make(chan LiveReloadEvent, 1)
+	l.lock.Lock()
+	l.clients[events] = struct{}{}
+	l.lock.Unlock()
+	defer func() {
+		l.lock.Lock()
+		delete(l.clients, events)
+		l.lock.Unlock()
+	}()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-events:
+			data, _ := json.Marshal(ev)
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Event, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// broadcast sends a result of a rebuild to all connected browsers
+func (l *liveReload) broadcast(err error) {
+	if l == nil {
+		return
+	}
+	ev := LiveReloadEvent{Event: LiveReloadRebuilt}
+	if err != nil {
+		ev = LiveReloadEvent{Event: LiveReloadFailed, Error: err.Error()}
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for c := range l.clients {
+		select {
+		case c <- ev:
+		default:
+			// browser did not read previous event yet, it will reload anyway
+		}
+	}
+}
+
+func (l *liveReload) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.server.Close()
+}
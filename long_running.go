@@ -2,8 +2,6 @@ package gbtb
 
 import (
 	"fmt"
-	"os"
-	"os/signal"
 	"time"
 )
 
@@ -13,26 +11,52 @@ type StoppableLongRunning struct {
 	Name string
 	// Dependencies of long running target
 	Dependencies Dependencies
+	// Services are names of long running tasks started as services before this
+	// task, each one once the previous one is ready. They keep running until build
+	// exits and are stopped in reverse order.
+	Services []string
 	// Job is stoppable job for long running task
 	Job func(chan struct{}) error
+	// Command is a command with arguments run if Job is nil,
+	// it's stopped according to StopOptions
+	Command []string
+	// ServiceOptions configures health checks and restarts
+	ServiceOptions
 
 	Stop chan struct{}
 }
 
-// Do runs long running task and waits for an interrupt from os
+// Do runs long running task until Stop is closed. If task has a readiness probe,
+// Do returns once it's ready and task keeps running in background.
 func (l *StoppableLongRunning) Do(tasks Tasks, runner *Runner) (time.Time, error) {
+	if err := requireServices(tasks, runner, l.Services, l.Name); err != nil {
+		fmt.Println(err)
+		return time.Time{}, err
+	}
 	stop := l.Stop
-	job := l.Job
+	svc := func() *service {
+		return newService(l.Name, l.Job, commandFactory(l.Command), false, l.ServiceOptions)
+	}
 	t := Task{
 		Name:         l.Name,
 		Dependencies: l.Dependencies,
-		Job: func() error {
-			return job(stop)
-		},
+		Job: Job(func() error {
+			return runService(svc(), stop)
+		}),
 	}
 	return t.Do(tasks, runner)
 }
 
+func (l *StoppableLongRunning) service(tasks Tasks, runner *Runner, path []string) (*service, error) {
+	if err := requireServices(tasks, runner, l.Services, path...); err != nil {
+		return nil, err
+	}
+	if err := buildDependencies(tasks, runner, l.Name, l.Dependencies); err != nil {
+		return nil, err
+	}
+	return newService(l.Name, l.Job, commandFactory(l.Command), false, l.ServiceOptions), nil
+}
+
 // DependsOn for long running task
 func (l *StoppableLongRunning) DependsOn() Dependencies {
 	return l.Dependencies
@@ -49,34 +73,53 @@ func (l *StoppableLongRunning) GetTask(name string) TaskLike {
 	return l
 }
 
-// LongRunning adds support for long running tasks stopped on os.Interrupt
+// LongRunning adds support for long running tasks stopped on os.Interrupt or SIGTERM
 type LongRunning struct {
 	// Name of long running target
 	Name string
 	// Dependencies of long running target
 	Dependencies Dependencies
+	// Services are names of long running tasks started as services before this
+	// task, each one once the previous one is ready. They keep running until build
+	// exits and are stopped in reverse order.
+	Services []string
 	// Job is stoppable job for long running task
 	Job func(chan struct{}) error
+	// Command is a command with arguments run if Job is nil,
+	// it's stopped according to StopOptions
+	Command []string
+	// ServiceOptions configures health checks and restarts
+	ServiceOptions
 }
 
 // Do runs long running task and waits for an interrupt from os
 func (l *LongRunning) Do(tasks Tasks, runner *Runner) (time.Time, error) {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	stop := make(chan struct{})
-	go func() {
-		<-c
-		close(stop)
-	}()
+	stop, release := interruptChannel()
+	defer release()
 	stoppable := StoppableLongRunning{
-		Name:         l.Name,
-		Dependencies: l.Dependencies,
-		Job:          l.Job,
-		Stop:         stop,
+		Name:           l.Name,
+		Dependencies:   l.Dependencies,
+		Services:       l.Services,
+		Job:            l.Job,
+		Command:        l.Command,
+		ServiceOptions: l.ServiceOptions,
+		Stop:           stop,
 	}
 	return stoppable.Do(tasks, runner)
 }
 
+func (l *LongRunning) service(tasks Tasks, runner *Runner, path []string) (*service, error) {
+	stoppable := StoppableLongRunning{
+		Name:           l.Name,
+		Dependencies:   l.Dependencies,
+		Services:       l.Services,
+		Job:            l.Job,
+		Command:        l.Command,
+		ServiceOptions: l.ServiceOptions,
+	}
+	return stoppable.service(tasks, runner, path)
+}
+
 // DependsOn for long running task
 func (l *LongRunning) DependsOn() Dependencies {
 	return l.Dependencies
@@ -94,111 +137,101 @@ func (l *LongRunning) GetTask(name string) TaskLike {
 func (l *LongRunning) Reset() {}
 
 // NotifyLongRunning start a long running task and restarts if there's a change detected
-// in file system
+// in file system. Service is restarted only after dependencies were rebuilt successfully,
+// if rebuild fails previous service keeps running.
 type NotifyLongRunning struct {
 	// Name of long running target
 	Name string
 	// Dependencies of long running target
 	Dependencies Dependencies
+	// Services are names of long running tasks started as services before this
+	// task, each one once the previous one is ready. They keep running until build
+	// exits and are stopped in reverse order.
+	Services []string
 	// Job is stoppable job for long running task
 	Job func(chan struct{}) error
-}
-
-func doStoppableAndLogError(f func(chan struct{}) error, stop chan struct{}) {
-}
-
-type stoppableJob struct {
-	j    func(chan struct{}) error
-	wait chan struct{}
-	stop chan struct{}
-}
-
-func (s *stoppableJob) Start() {
-	stop := make(chan struct{})
-	go func() {
-		if err := s.j(stop); err != nil {
-			fmt.Println(err)
-		}
-		s.wait <- struct{}{}
-	}()
-	s.stop = stop
-}
-
-func (s *stoppableJob) Wait() {
-	close(s.stop)
-	<-s.wait
-}
-
-func newStoppableJob(f func(chan struct{}) error) stoppableJob {
-	return stoppableJob{
-		j:    f,
-		wait: make(chan struct{}),
-	}
-}
-
-func (l *NotifyLongRunning) doStoppable(tasks Tasks, runner *Runner) (chan struct{}, chan struct{}) {
-	restart := make(chan struct{})
-	j := l.Job
-	stoppable := StoppableLongRunning{
-		Name:         l.Name,
-		Dependencies: l.Dependencies,
-		Job: func(restart chan struct{}) error {
-			stoppable := newStoppableJob(j)
-			stoppable.Start()
-			for range restart {
-				stoppable.Wait()
-				stoppable.Start()
-			}
-			stoppable.Wait()
-			return nil
-		},
-		Stop: restart,
-	}
-	wait := make(chan struct{})
-	go func() {
-		stoppable.Do(tasks, runner)
-		close(wait)
-	}()
-	return wait, restart
+	// Command is a command with arguments run if Job is nil,
+	// it's stopped according to StopOptions
+	Command []string
+	// Debounce is a quiet period after the last change before restart,
+	// check out Notify.Debounce
+	Debounce time.Duration
+	// Ignore is a list of glob patterns of paths which changes are ignored,
+	// check out Notify.Ignore
+	Ignore []string
+	// GitIgnore adds patterns from .gitignore in working directory to Ignore
+	GitIgnore bool
+	// IgnoreOps is a set of operations that do not trigger a restart
+	IgnoreOps WatchOp
+	// LiveReload is an address of live reload server notifying browsers
+	// after restart, check out Notify.LiveReload
+	LiveReload string
+	// ServiceOptions configures health checks and restarts, service is restarted
+	// on change once it's ready
+	ServiceOptions
+	// Overlap starts a new service before the previous one is stopped,
+	// previous service is stopped once the new one is ready. If the new one
+	// does not become ready, it is stopped and previous one keeps running.
+	// Both services run at the same time, so they must not conflict,
+	// for example by listening on the same port without SO_REUSEPORT.
+	Overlap bool
 }
 
 // Do runs long running task and waits for an interrupt from os
 func (l *NotifyLongRunning) Do(tasks Tasks, runner *Runner) (time.Time, error) {
-	wait, restart := l.doStoppable(tasks, runner)
-	defer func() {
-		close(restart)
-		<-wait
-	}()
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	stop := make(chan struct{})
-	go func() {
-		<-c
-		close(stop)
-	}()
+	if err := requireServices(tasks, runner, l.Services, l.Name); err != nil {
+		fmt.Println(err)
+		return time.Time{}, err
+	}
+	svc := newService(l.Name, l.Job, commandFactory(l.Command), l.Overlap, l.ServiceOptions)
+	defer svc.Close()
+	stop, release := interruptChannel()
+	defer release()
 	notify := Notify{
+		Job:        l.Name,
+		LiveReload: l.LiveReload,
 		Task: &Task{
 			Name:         l.Name,
 			Dependencies: l.Dependencies,
-			Job: func() error {
-				restart <- struct{}{}
-				return nil
-			},
+			// Task runs Job only if all dependencies were built
+			Job: Job(svc.Restart),
 		},
 	}
-	fdeps, tdeps, err := notify.deps(tasks, notify.Task)
+	opts, err := newWatchOptions(l.Debounce, l.Ignore, l.GitIgnore, l.IgnoreOps)
+	if err != nil {
+		return time.Time{}, err
+	}
+	roots := map[string]TaskLike{l.Name: notify.Task}
+	session, err := newWatchSession(tasks, roots, opts)
 	if err != nil {
 		return time.Time{}, err
 	}
+	defer session.Close()
+	// first start, if it fails service is started after the next successful rebuild
+	notify.Task.Do(tasks, runner)
 	return time.Time{}, notify.job(
 		tasks,
 		runner,
-		fdeps,
-		tdeps,
+		session,
+		newWatchControl(l.Name, roots, svc.Restart),
 		stop,
 	)
 }
 
+// service of NotifyLongRunning is not restarted on changes when it's
+// started as a service of other task
+func (l *NotifyLongRunning) service(tasks Tasks, runner *Runner, path []string) (*service, error) {
+	stoppable := StoppableLongRunning{
+		Name:           l.Name,
+		Dependencies:   l.Dependencies,
+		Services:       l.Services,
+		Job:            l.Job,
+		Command:        l.Command,
+		ServiceOptions: l.ServiceOptions,
+	}
+	return stoppable.service(tasks, runner, path)
+}
+
 // DependsOn for long running task
 func (l *NotifyLongRunning) DependsOn() Dependencies {
 	return l.Dependencies
@@ -11,8 +11,18 @@ type MultiTargetTask struct {
 	Job          MultiTargetJob
 	ModTime      MultiTargetModTime
 	Dependencies MultiTargetDependencies
-	lock         sync.Mutex
-	tasks        map[string]*Task
+	// EnvJob is a job of a target ran in task environment, check out Task.EnvJob
+	EnvJob MultiTargetEnvJob
+	// Env is a list of environment variables set for commands ran by
+	// EnvJob, check out Task.Env
+	Env []string
+	// Dir is a working directory of commands ran by EnvJob
+	Dir string
+	// Pty runs commands of EnvJob under pseudo-terminal, check out Task.Pty
+	Pty bool
+
+	lock  sync.Mutex
+	tasks map[string]*Task
 }
 
 // GetNames defined for MultiTargetTask
@@ -32,12 +42,20 @@ func (m *MultiTargetTask) init() {
 func (m *MultiTargetTask) createTask(name string) {
 	task := Task{
 		Name: name,
+		Env:  m.Env,
+		Dir:  m.Dir,
+		Pty:  m.Pty,
 	}
 	if m.Job != nil {
 		task.Job = func() error {
 			return m.Job(name)
 		}
 	}
+	if m.EnvJob != nil {
+		task.EnvJob = func(env JobEnv) error {
+			return m.EnvJob(name, env)
+		}
+	}
 	if m.ModTime != nil {
 		task.ModTime = func() (time.Time, error) {
 			return m.ModTime(name)
@@ -0,0 +1,279 @@
+package gbtb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	// WatchPoll makes Notify poll file system for changes instead of using
+	// file system notifications
+	WatchPoll bool
+	// WatchPollInterval is an interval between file system scans when polling
+	WatchPollInterval = time.Second
+)
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+	mode    os.FileMode
+}
+
+func newFileState(fi os.FileInfo) fileState {
+	return fileState{fi.ModTime(), fi.Size(), fi.Mode()}
+}
+
+// snapshot returns state of a file, or a directory and files in it
+func snapshot(name string) (map[string]fileState, error) {
+	st, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	snap := map[string]fileState{name: newFileState(st)}
+	if st.IsDir() {
+		entries, err := ioutil.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			snap[filepath.Join(name, e.Name())] = newFileState(e)
+		}
+	}
+	return snap, nil
+}
+
+func diffSnapshots(prev, next map[string]fileState) []watchEvent {
+	var events []watchEvent
+	for name, ns := range next {
+		ps, ok := prev[name]
+		switch {
+		case !ok:
+			events = append(events, watchEvent{name, WatchCreate})
+		case !ps.modTime.Equal(ns.modTime) || ps.size != ns.size:
+			events = append(events, watchEvent{name, WatchWrite})
+		case ps.mode != ns.mode:
+			events = append(events, watchEvent{name, WatchChmod})
+		}
+	}
+	for name := range prev {
+		if _, ok := next[name]; !ok {
+			events = append(events, watchEvent{name, WatchRemove})
+		}
+	}
+	return events
+}
+
+// pollingWatcher detects changes by scanning watched files and directories
+// in intervals. Useful where file system notifications do not work, like
+// network file systems or bind mounts.
+type pollingWatcher struct {
+	lock    sync.Mutex
+	watches map[string]map[string]fileState
+	events  chan watchEvent
+	errors  chan error
+	done    chan struct{}
+}
+
+func newPollingWatcher(interval time.Duration) *pollingWatcher {
+	p := &pollingWatcher{
+		watches: make(map[string]map[string]fileState),
+		events:  make(chan watchEvent),
+		errors:  make(chan error),
+		done:    make(chan struct{}),
+	}
+	go p.run(interval)
+	return p
+}
+
+func (p *pollingWatcher) run(interval time.Duration) {
+	defer close(p.events)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		for _, ev := range p.poll() {
+			select {
+			case p.events <- ev:
+			case <-p.done:
+				return
+			}
+		}
+	}
+}
+
+func (p *pollingWatcher) poll() []watchEvent {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var events []watchEvent
+	for name, prev := range p.watches {
+		next, err := snapshot(name)
+		if err != nil {
+			// removed, watch stays so that recreating it is detected
+			next = map[string]fileState{}
+		}
+		events = append(events, diffSnapshots(prev, next)...)
+		p.watches[name] = next
+	}
+	return events
+}
+
+func (p *pollingWatcher) Add(name string) error {
+	snap, err := snapshot(name)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.watches[name] = snap
+	return nil
+}
+
+func (p *pollingWatcher) Remove(name string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.watches, name)
+	return nil
+}
+
+func (p *pollingWatcher) Events() <-chan watchEvent {
+	return p.events
+}
+
+func (p *pollingWatcher) Errors() <-chan error {
+	return p.errors
+}
+
+func (p *pollingWatcher) Close() error {
+	close(p.done)
+	return nil
+}
+
+// fallbackWatcher uses file system notifications and switches to polling
+// if they fail, for example when inotify watch limit is exhausted
+type fallbackWatcher struct {
+	interval time.Duration
+
+	lock    sync.Mutex
+	current fileWatcher
+	polling bool
+	paths   map[string]struct{}
+	events  chan watchEvent
+	errors  chan error
+	done    chan struct{}
+}
+
+// newFileWatcher returns a watcher used by Notify, polling watcher is used
+// if WatchPoll is set or file system notifications are not available
+func newFileWatcher() fileWatcher {
+	f := &fallbackWatcher{
+		interval: WatchPollInterval,
+		paths:    make(map[string]struct{}),
+		events:   make(chan watchEvent),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+	}
+	var w fileWatcher
+	if !WatchPoll {
+		if fw, err := newFsnotifyWatcher(); err == nil {
+			w = fw
+		} else {
+			fmt.Printf("file system notifications unavailable, polling for changes: %v\n", err)
+		}
+	}
+	if w == nil {
+		w = newPollingWatcher(f.interval)
+		f.polling = true
+	}
+	f.current = w
+	go f.forward(w)
+	return f
+}
+
+func (f *fallbackWatcher) forward(w fileWatcher) {
+	events, errors := w.Events(), w.Errors()
+	for events != nil {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			select {
+			case f.events <- ev:
+			case <-f.done:
+				return
+			}
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			select {
+			case f.errors <- err:
+			case <-f.done:
+				return
+			}
+		}
+	}
+}
+
+// switchToPolling replaces file system notifications with polling and
+// adds all watched paths to polling watcher. Must be called with lock held.
+func (f *fallbackWatcher) switchToPolling(cause error) error {
+	fmt.Printf("file system notifications failed, polling for changes: %v\n", cause)
+	f.current.Close()
+	p := newPollingWatcher(f.interval)
+	for name := range f.paths {
+		if err := p.Add(name); err != nil && !os.IsNotExist(err) {
+			p.Close()
+			return err
+		}
+	}
+	f.current, f.polling = p, true
+	go f.forward(p)
+	return nil
+}
+
+func (f *fallbackWatcher) Add(name string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	err := f.current.Add(name)
+	if err != nil && !os.IsNotExist(err) && !f.polling {
+		if err = f.switchToPolling(err); err == nil {
+			err = f.current.Add(name)
+		}
+	}
+	if err == nil {
+		f.paths[name] = struct{}{}
+	}
+	return err
+}
+
+func (f *fallbackWatcher) Remove(name string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.paths, name)
+	return f.current.Remove(name)
+}
+
+func (f *fallbackWatcher) Events() <-chan watchEvent {
+	return f.events
+}
+
+func (f *fallbackWatcher) Errors() <-chan error {
+	return f.errors
+}
+
+func (f *fallbackWatcher) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	close(f.done)
+	return f.current.Close()
+}
@@ -0,0 +1,181 @@
+package gbtb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// probeTimeout is a timeout of a single network probe
+const probeTimeout = time.Second
+
+// Probe checks a state of a service, returns nil if service is ready or alive
+type Probe interface {
+	Check() error
+}
+
+// ProbeFunc is a custom probe
+type ProbeFunc func() error
+
+// Check calls the probe function
+func (p ProbeFunc) Check() error {
+	return p()
+}
+
+// TCPProbe succeeds if a TCP connection to addr can be established
+func TCPProbe(addr string) ProbeFunc {
+	return func() error {
+		conn, err := net.DialTimeout("tcp", addr, probeTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// HTTPProbe succeeds if GET request to url returns 2xx status code
+func HTTPProbe(url string) ProbeFunc {
+	client := &http.Client{Timeout: probeTimeout}
+	return func() error {
+		resp, err := client.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(ioutil.Discard, resp.Body)
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("GET %s returned %s", url, resp.Status)
+		}
+		return nil
+	}
+}
+
+// LogProbe succeeds once a line matching a pattern is written to it.
+// Output of Command of long running tasks and of Command and Script of
+// Supervisor processes is written to their probes. Job has to write output
+// of it's commands to a probe itself, for example
+//
+//	cmd.Stdout = probe
+//	return RunStoppableCommands(stop, cmd)
+//
+// Output written to a probe is copied to Output. Probe is reset when service
+// is (re)started.
+type LogProbe struct {
+	// Output is a writer output is copied to, defaults to stdout
+	Output io.Writer
+
+	pattern *regexp.Regexp
+	lock    sync.Mutex
+	line    []byte
+	matched bool
+}
+
+// NewLogProbe returns a probe matching output lines against regular expression pattern
+func NewLogProbe(pattern string) (*LogProbe, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &LogProbe{pattern: re}, nil
+}
+
+// MustLogProbe returns a probe matching output lines against regular expression pattern
+// panicing on error
+func MustLogProbe(pattern string) *LogProbe {
+	l, err := NewLogProbe(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// scan matches complete lines of p appended to a partial line, returns
+// a new partial line. Lock must be held.
+func (l *LogProbe) scan(line, p []byte) []byte {
+	line = append(line, p...)
+	for {
+		i := bytes.IndexByte(line, '\n')
+		if i < 0 {
+			return line
+		}
+		l.matched = l.matched || l.pattern.Match(line[:i])
+		line = line[i+1:]
+	}
+}
+
+func (l *LogProbe) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	l.line = l.scan(l.line, p)
+	l.lock.Unlock()
+	out := l.Output
+	if out == nil {
+		out = stdout
+	}
+	return out.Write(p)
+}
+
+// Check returns nil if matching line was written since the last reset
+func (l *LogProbe) Check() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.matched {
+		return fmt.Errorf("no line matching %s", l.pattern)
+	}
+	return nil
+}
+
+// Reset forgets matched lines
+func (l *LogProbe) Reset() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.matched = false
+	l.line = nil
+}
+
+// logProbeWriter matches output of a single stream against a LogProbe
+// without copying it
+type logProbeWriter struct {
+	probe *LogProbe
+	line  []byte
+}
+
+func (w *logProbeWriter) Write(p []byte) (int, error) {
+	w.probe.lock.Lock()
+	defer w.probe.lock.Unlock()
+	w.line = w.probe.scan(w.line, p)
+	return len(p), nil
+}
+
+// probeOutput returns a writer copying output written to w, or to def if w is
+// nil, to probes that check output, like LogProbe. Returns w if there are no
+// such probes.
+func probeOutput(w, def io.Writer, probes ...Probe) io.Writer {
+	out := w
+	if out == nil {
+		out = def
+	}
+	writers := []io.Writer{out}
+	for _, p := range probes {
+		switch p := p.(type) {
+		case *LogProbe:
+			writers = append(writers, &logProbeWriter{probe: p})
+		case io.Writer:
+			writers = append(writers, p)
+		}
+	}
+	if len(writers) == 1 {
+		return w
+	}
+	return io.MultiWriter(writers...)
+}
+
+// probeResetter is a probe that has a state reset on service start
+type probeResetter interface {
+	Reset()
+}
@@ -0,0 +1,115 @@
+package gbtb
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// processTracker keeps track of process groups of commands started by gbtb,
+// so that they can be signaled and killed on exit
+type processTracker struct {
+	lock sync.Mutex
+	// cmds maps a started command to true if it gets os interrupts forwarded
+	cmds map[*exec.Cmd]bool
+	// forwarders is a number of active interrupt forwarders
+	forwarders int
+}
+
+var processes = processTracker{cmds: make(map[*exec.Cmd]bool)}
+
+// add tracks a started command, commands with forward set get interrupts
+// forwarded to them, others are expected to be stopped by their caller
+func (p *processTracker) add(cmd *exec.Cmd, forward bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.cmds[cmd] = forward
+}
+
+// done stops tracking a command that was waited for, unless some processes
+// in it's group are still running
+func (p *processTracker) done(cmd *exec.Cmd) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !processGroupAlive(cmd) {
+		delete(p.cmds, cmd)
+	}
+}
+
+// forwarding returns true if interrupts are forwarded to commands
+func (p *processTracker) forwarding() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.forwarders > 0
+}
+
+// ownProcessGroup returns true if cmd should run in it's own process group.
+// Commands get interrupts from terminal unless gbtb forwards them, and commands
+// reading from terminal must stay in foreground process group to read it.
+func ownProcessGroup(cmd *exec.Cmd) bool {
+	if f, ok := cmd.Stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		return false
+	}
+	return processes.forwarding()
+}
+
+// signal signals process groups of commands that get interrupts forwarded
+func (p *processTracker) signal(sig os.Signal) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for cmd, forward := range p.cmds {
+		if forward {
+			signalProcess(cmd, sig)
+		}
+	}
+}
+
+// killAll kills process groups of all commands, including processes
+// orphaned by commands that already exited. Groups of exited commands are
+// checked again, so that a reused id of a group that ended is not killed.
+func (p *processTracker) killAll() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for cmd := range p.cmds {
+		if cmd.ProcessState == nil || processGroupAlive(cmd) {
+			killProcess(cmd)
+		}
+		delete(p.cmds, cmd)
+	}
+}
+
+// stopSignals are signals stopping gbtb, os.Interrupt and SIGTERM
+var stopSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// forwardInterrupts forwards interrupts to commands, which run in their own
+// process groups while it's active and do not get them from terminal. Second
+// interrupt kills all commands and exits. Returns a function releasing signal handler.
+func forwardInterrupts() func() {
+	processes.lock.Lock()
+	processes.forwarders++
+	processes.lock.Unlock()
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, stopSignals...)
+	go func() {
+		interrupted := false
+		for sig := range c {
+			if interrupted {
+				processes.killAll()
+				os.Exit(1)
+			}
+			interrupted = true
+			processes.signal(sig)
+		}
+	}()
+	return func() {
+		signal.Stop(c)
+		close(c)
+		processes.lock.Lock()
+		processes.forwarders--
+		processes.lock.Unlock()
+	}
+}
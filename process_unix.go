@@ -0,0 +1,52 @@
+//go:build !windows
+// +build !windows
+
+package gbtb
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes a command run in it's own process group, so that
+// the command along with all processes it starts can be signaled at once
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// processGroup returns a process group id of a started command
+func processGroup(cmd *exec.Cmd) (int, bool) {
+	// command started in a new session is a leader of a new process group
+	if cmd.Process == nil || cmd.SysProcAttr == nil || !(cmd.SysProcAttr.Setpgid || cmd.SysProcAttr.Setsid) {
+		return 0, false
+	}
+	return cmd.Process.Pid, true
+}
+
+// signalProcess signals a process group of a command
+func signalProcess(cmd *exec.Cmd, sig os.Signal) error {
+	if s, ok := sig.(syscall.Signal); ok {
+		if pgid, ok := processGroup(cmd); ok {
+			return syscall.Kill(-pgid, s)
+		}
+	}
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Signal(sig)
+}
+
+// killProcess kills a process group of a command
+func killProcess(cmd *exec.Cmd) error {
+	return signalProcess(cmd, syscall.SIGKILL)
+}
+
+// processGroupAlive returns true if any process in a group of a command is running
+func processGroupAlive(cmd *exec.Cmd) bool {
+	pgid, ok := processGroup(cmd)
+	return ok && syscall.Kill(-pgid, 0) == nil
+}
@@ -0,0 +1,33 @@
+//go:build windows
+// +build windows
+
+package gbtb
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup does nothing, windows has no process groups
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalProcess signals a process of a command
+func signalProcess(cmd *exec.Cmd, sig os.Signal) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Signal(sig)
+}
+
+// killProcess kills a process of a command
+func killProcess(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
+
+// processGroupAlive is always false, process is waited for already
+func processGroupAlive(cmd *exec.Cmd) bool {
+	return false
+}
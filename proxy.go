@@ -0,0 +1,123 @@
+package gbtb
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// defaultProxyTimeout is a maximum time a request is held by a proxy
+const defaultProxyTimeout = 30 * time.Second
+
+// proxyDialInterval is an interval between attempts to connect to a service
+const proxyDialInterval = 100 * time.Millisecond
+
+// devProxy is a reverse proxy to a service that holds requests while service
+// is restarted instead of failing them
+type devProxy struct {
+	name    string
+	timeout time.Duration
+	proxy   *httputil.ReverseProxy
+	server  *http.Server
+
+	lock sync.Mutex
+	// available is closed when service can handle requests
+	available chan struct{}
+}
+
+// newDevProxy starts a proxy listening on addr forwarding requests to target,
+// requests are held until service is released
+func newDevProxy(name, addr, target string, timeout time.Duration) (*devProxy, error) {
+	if timeout <= 0 {
+		timeout = defaultProxyTimeout
+	}
+	u, err := url.Parse("http://" + target)
+	if err != nil {
+		return nil, err
+	}
+	p := &devProxy{
+		name:      name,
+		timeout:   timeout,
+		proxy:     httputil.NewSingleHostReverseProxy(u),
+		available: make(chan struct{}),
+	}
+	p.proxy.Transport = &http.Transport{
+		Proxy:       http.ProxyFromEnvironment,
+		DialContext: p.dial,
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	p.server = &http.Server{Handler: p}
+	go p.server.Serve(l)
+	fmt.Printf("proxy for %s listening on %s\n", name, l.Addr())
+	return p, nil
+}
+
+// dial connects to a service retrying until it accepts connections, service
+// could be still starting up
+func (p *devProxy) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	var d net.Dialer
+	for {
+		conn, err := d.DialContext(ctx, network, addr)
+		if err == nil {
+			return conn, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(proxyDialInterval):
+		}
+	}
+}
+
+// hold makes proxy hold requests until release
+func (p *devProxy) hold() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	select {
+	case <-p.available:
+		p.available = make(chan struct{})
+	default:
+	}
+}
+
+// release forwards held requests
+func (p *devProxy) release() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	select {
+	case <-p.available:
+	default:
+		close(p.available)
+	}
+}
+
+func (p *devProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.lock.Lock()
+	available := p.available
+	p.lock.Unlock()
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	select {
+	case <-available:
+	case <-r.Context().Done():
+		return
+	case <-timer.C:
+		http.Error(w, fmt.Sprintf("%s is not available", p.name), http.StatusServiceUnavailable)
+		return
+	}
+	p.proxy.ServeHTTP(w, r)
+}
+
+func (p *devProxy) Close() error {
+	return p.server.Close()
+}
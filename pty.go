@@ -0,0 +1,52 @@
+package gbtb
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// Pty makes commands run the last command of a pipeline under a pseudo-terminal,
+// so that tools which disable colors when not writing to a terminal keep them.
+// Pseudo-terminal is only used on linux when stdout of gbtb is a terminal and
+// last command has no Stdout set.
+var Pty bool
+
+// ptys keeps cleanup functions of commands started under pseudo-terminal
+var ptys = struct {
+	sync.Mutex
+	m map[*exec.Cmd]func()
+}{m: make(map[*exec.Cmd]func())}
+
+// usePty returns true if cmd should be started under pseudo-terminal
+func usePty(cmd *exec.Cmd) bool {
+	return ptySupported && cmd.Stdout == nil && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// startCommand starts a command, under pseudo-terminal if pty is set
+func startCommand(cmd *exec.Cmd, pty bool) error {
+	if !pty {
+		return cmd.Start()
+	}
+	cleanup, err := startPty(cmd)
+	if err != nil {
+		return err
+	}
+	ptys.Lock()
+	defer ptys.Unlock()
+	ptys.m[cmd] = cleanup
+	return nil
+}
+
+// closePty waits for output of a command started under pseudo-terminal and releases it
+func closePty(cmd *exec.Cmd) {
+	ptys.Lock()
+	cleanup, ok := ptys.m[cmd]
+	delete(ptys.m, cmd)
+	ptys.Unlock()
+	if ok {
+		cleanup()
+	}
+}
@@ -0,0 +1,68 @@
+package gbtb
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+const ptySupported = true
+
+// ptyDrainTimeout is a time output of a pseudo-terminal is read after command exits,
+// processes started in background by command can keep it open
+const ptyDrainTimeout = time.Second
+
+// startPty starts cmd with stdout, and stderr unless set, connected to a new
+// pseudo-terminal sized like terminal of gbtb. Output is copied to stdout.
+// Returns a function waiting for output and closing pseudo-terminal.
+func startPty(cmd *exec.Cmd) (func(), error) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+	pty.InheritSize(os.Stdout, ptmx)
+	cmd.Stdout = tty
+	if cmd.Stderr == nil {
+		cmd.Stderr = tty
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	// new session is a new process group as well
+	cmd.SysProcAttr.Setpgid = false
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 1
+	if err := cmd.Start(); err != nil {
+		ptmx.Close()
+		return nil, err
+	}
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	go func() {
+		for range winch {
+			pty.InheritSize(os.Stdout, ptmx)
+		}
+	}()
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		// reading fails with EIO once all processes closed pseudo-terminal
+		io.Copy(stdout, ptmx)
+	}()
+	return func() {
+		signal.Stop(winch)
+		close(winch)
+		select {
+		case <-copied:
+		case <-time.After(ptyDrainTimeout):
+		}
+		ptmx.Close()
+	}, nil
+}
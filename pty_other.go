@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package gbtb
+
+import (
+	"errors"
+	"os/exec"
+)
+
+const ptySupported = false
+
+func startPty(cmd *exec.Cmd) (func(), error) {
+	return nil, errors.New("pseudo-terminal is not supported")
+}
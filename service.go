@@ -0,0 +1,410 @@
+package gbtb
+
+import (
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// RestartPolicy defines when a long running service is restarted after it exits
+type RestartPolicy int
+
+// Restart policies
+const (
+	// RestartNever does not restart a service
+	RestartNever RestartPolicy = iota
+	// RestartOnFailure restarts a service if it exits with an error
+	// or fails liveness probe
+	RestartOnFailure
+	// RestartAlways restarts a service whenever it exits
+	RestartAlways
+)
+
+// Defaults of ServiceOptions
+const (
+	defaultReadinessTimeout = 30 * time.Second
+	defaultLivenessPeriod   = 10 * time.Second
+	defaultLivenessFailures = 3
+	defaultRestartDelay     = time.Second
+	defaultMaxRestartDelay  = 30 * time.Second
+)
+
+// readinessInterval is an interval between readiness checks of a started service
+const readinessInterval = 200 * time.Millisecond
+
+// backgroundServices are services running after their task finished,
+// they are waited for before build exits
+var backgroundServices sync.WaitGroup
+
+// ServiceOptions configures health checks and restarts of a long running service
+type ServiceOptions struct {
+	// Readiness checks if service is ready to serve, it's checked repeatedly after
+	// start until it succeeds. Service with readiness probe runs in background once
+	// ready, so that tasks depending on it can run.
+	Readiness Probe
+	// ReadinessTimeout is a time service has to become ready after start,
+	// defaults to 30 seconds
+	ReadinessTimeout time.Duration
+	// Liveness checks if running service is alive, it's checked every LivenessPeriod
+	Liveness Probe
+	// LivenessPeriod is an interval between liveness checks, defaults to 10 seconds
+	LivenessPeriod time.Duration
+	// LivenessFailures is a number of consecutive liveness check failures
+	// after which service is stopped and restarted, defaults to 3
+	LivenessFailures int
+	// Restart is a policy of restarting a service after it exits
+	Restart RestartPolicy
+	// RestartDelay is a delay before the first restart, doubled with every
+	// following restart up to MaxRestartDelay, defaults to one second
+	RestartDelay time.Duration
+	// MaxRestartDelay is a maximum delay between restarts, defaults to 30 seconds.
+	// Delay is reset if service was running longer than MaxRestartDelay.
+	MaxRestartDelay time.Duration
+	// ProxyAddr is an address of a development reverse proxy forwarding HTTP
+	// requests to ProxyTarget, for example ":8080". Requests are held while
+	// service is (re)started instead of failing. Proxy is not started if empty.
+	ProxyAddr string
+	// ProxyTarget is an address service listens on, for example "localhost:8081"
+	ProxyTarget string
+	// ProxyTimeout is a maximum time a request is held, defaults to 30 seconds
+	ProxyTimeout time.Duration
+	// StopOptions configures how service is stopped. Job is stopped by closing
+	// it's stop channel after PreStop and it's waited for no longer than GracePeriod,
+	// if set. Signal and GracePeriod apply to Command and to commands Job runs with
+	// default StopOptions and it's stop channel, like StoppableCommandJob does.
+	StopOptions
+}
+
+func (o ServiceOptions) withDefaults() ServiceOptions {
+	if o.ReadinessTimeout <= 0 {
+		o.ReadinessTimeout = defaultReadinessTimeout
+	}
+	if o.LivenessPeriod <= 0 {
+		o.LivenessPeriod = defaultLivenessPeriod
+	}
+	if o.LivenessFailures <= 0 {
+		o.LivenessFailures = defaultLivenessFailures
+	}
+	if o.RestartDelay <= 0 {
+		o.RestartDelay = defaultRestartDelay
+	}
+	if o.MaxRestartDelay <= 0 {
+		o.MaxRestartDelay = defaultMaxRestartDelay
+	}
+	return o
+}
+
+type stoppableJob struct {
+	stop    chan struct{}
+	done    chan struct{}
+	err     error
+	started time.Time
+}
+
+// startStoppableJob runs f until stopped
+func startStoppableJob(f func(chan struct{}) error) *stoppableJob {
+	s := &stoppableJob{
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		started: time.Now(),
+	}
+	go func() {
+		defer close(s.done)
+		if s.err = f(s.stop); s.err != nil {
+			fmt.Println(s.err)
+		}
+	}()
+	return s
+}
+
+// Stop stops job and waits until it exits
+func (s *stoppableJob) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+// service keeps a long running job running, restarting it on request
+// or according to restart policy
+type service struct {
+	name    string
+	job     func(chan struct{}) error
+	command bool
+	overlap bool
+	opts    ServiceOptions
+
+	current  *stoppableJob
+	proxy    *devProxy
+	requests chan chan error
+	// ended receives an error of a service that exited and won't be restarted
+	ended chan error
+	wait  chan struct{}
+}
+
+// commandFactory returns a function creating a command, nil if args are empty
+func commandFactory(args []string) func() *exec.Cmd {
+	if len(args) == 0 {
+		return nil
+	}
+	return func() *exec.Cmd {
+		return exec.Command(args[0], args[1:]...)
+	}
+}
+
+// newService returns a service running job, or a command created by command if job
+// is nil. Service is not started until restarted.
+func newService(name string, job func(chan struct{}) error, command func() *exec.Cmd, overlap bool, opts ServiceOptions) *service {
+	s := &service{
+		name:     name,
+		job:      job,
+		overlap:  overlap,
+		opts:     opts.withDefaults(),
+		requests: make(chan chan error),
+		ended:    make(chan error, 1),
+		wait:     make(chan struct{}),
+	}
+	if s.job == nil {
+		if command == nil {
+			s.job = func(chan struct{}) error {
+				return fmt.Errorf("%s has neither job nor command", name)
+			}
+		} else {
+			s.job = func(stop chan struct{}) error {
+				cmd := command()
+				cmd.Stdout = probeOutput(cmd.Stdout, stdout, opts.Readiness, opts.Liveness)
+				cmd.Stderr = probeOutput(cmd.Stderr, stderr, opts.Readiness, opts.Liveness)
+				return opts.StopOptions.RunStoppableCommands(stop, cmd)
+			}
+			s.command = true
+		}
+	}
+	if opts.ProxyAddr != "" {
+		var err error
+		if s.proxy, err = newDevProxy(name, opts.ProxyAddr, opts.ProxyTarget, opts.ProxyTimeout); err != nil {
+			fmt.Printf("could not start proxy for %s: %v\n", name, err)
+		}
+	}
+	go s.serve()
+	return s
+}
+
+// hold makes proxy hold requests while service is restarted
+func (s *service) hold() {
+	if s.proxy != nil {
+		s.proxy.hold()
+	}
+}
+
+// release makes proxy forward requests
+func (s *service) release() {
+	if s.proxy != nil {
+		s.proxy.release()
+	}
+}
+
+// Restart starts a service or replaces running one with a new one,
+// returns once new one is ready
+func (s *service) Restart() error {
+	reply := make(chan error)
+	s.requests <- reply
+	return <-reply
+}
+
+// Close stops a service
+func (s *service) Close() {
+	close(s.requests)
+	<-s.wait
+}
+
+// waitReady waits until a started service is ready
+func (s *service) waitReady(j *stoppableJob) error {
+	if s.opts.Readiness == nil {
+		return nil
+	}
+	deadline := time.NewTimer(s.opts.ReadinessTimeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(readinessInterval)
+	defer ticker.Stop()
+	for {
+		err := s.opts.Readiness.Check()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-j.done:
+			return fmt.Errorf("%s exited before it became ready: %v", s.name, j.err)
+		case <-deadline.C:
+			return fmt.Errorf("%s is not ready after %v: %v", s.name, s.opts.ReadinessTimeout, err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// stop stops a running job, command handles StopOptions by itself
+func (s *service) stop(j *stoppableJob) {
+	if s.command {
+		j.Stop()
+		return
+	}
+	s.opts.StopOptions.stopJob(s.name, j)
+}
+
+func (s *service) start() *stoppableJob {
+	for _, p := range []Probe{s.opts.Readiness, s.opts.Liveness} {
+		if r, ok := p.(probeResetter); ok {
+			r.Reset()
+		}
+	}
+	if s.command {
+		return startStoppableJob(s.job)
+	}
+	return startStoppableJob(s.opts.StopOptions.withJobStopOptions(s.job))
+}
+
+// restart replaces a running service with a new one. With overlap new
+// one is started before the running one is stopped, and if it does not
+// become ready, running one is kept.
+func (s *service) restart() error {
+	if s.current == nil || !s.overlap {
+		s.hold()
+		defer s.release()
+		if s.current != nil {
+			s.stop(s.current)
+		}
+		s.current = s.start()
+		return s.waitReady(s.current)
+	}
+	next := s.start()
+	if err := s.waitReady(next); err != nil {
+		s.stop(next)
+		return fmt.Errorf("previous %s is kept running: %v", s.name, err)
+	}
+	s.stop(s.current)
+	s.current = next
+	return nil
+}
+
+// shouldRestart returns true if a service that exited with err is restarted
+func (s *service) shouldRestart(err error) bool {
+	switch s.opts.Restart {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return err != nil
+	}
+	return false
+}
+
+func (s *service) serve() {
+	defer close(s.wait)
+	delay := s.opts.RestartDelay
+	var retry <-chan time.Time
+	var liveness <-chan time.Time
+	if s.opts.Liveness != nil {
+		ticker := time.NewTicker(s.opts.LivenessPeriod)
+		defer ticker.Stop()
+		liveness = ticker.C
+	}
+	failures := 0
+	// scheduleRestart restarts service after a delay growing exponentially
+	scheduleRestart := func(ran time.Duration) {
+		// requests wait for restarted service
+		s.hold()
+		if ran > s.opts.MaxRestartDelay {
+			delay = s.opts.RestartDelay
+		}
+		fmt.Printf("restarting %s in %v\n", s.name, delay)
+		retry = time.After(delay)
+		if delay *= 2; delay > s.opts.MaxRestartDelay {
+			delay = s.opts.MaxRestartDelay
+		}
+	}
+	for {
+		var done chan struct{}
+		if s.current != nil {
+			done = s.current.done
+		}
+		select {
+		case reply, ok := <-s.requests:
+			if !ok {
+				if s.current != nil {
+					s.stop(s.current)
+				}
+				if s.proxy != nil {
+					s.proxy.Close()
+				}
+				return
+			}
+			retry, failures = nil, 0
+			reply <- s.restart()
+		case <-done:
+			j := s.current
+			s.current = nil
+			if s.shouldRestart(j.err) {
+				scheduleRestart(time.Since(j.started))
+				continue
+			}
+			select {
+			case s.ended <- j.err:
+			default:
+			}
+		case <-retry:
+			retry, failures = nil, 0
+			if err := s.restart(); err != nil {
+				fmt.Println(err)
+			}
+		case <-liveness:
+			if s.current == nil {
+				continue
+			}
+			err := s.opts.Liveness.Check()
+			if err == nil {
+				failures = 0
+				continue
+			}
+			if failures++; failures < s.opts.LivenessFailures {
+				continue
+			}
+			fmt.Printf("%s is not alive: %v\n", s.name, err)
+			j := s.current
+			s.stop(j)
+			s.current, failures = nil, 0
+			if s.opts.Restart != RestartNever {
+				scheduleRestart(time.Since(j.started))
+			} else {
+				select {
+				case s.ended <- err:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// runService starts a service and keeps it running until stop is closed or it
+// ends. Service with a readiness probe is left running in background once ready.
+func runService(s *service, stop chan struct{}) error {
+	if err := s.Restart(); err != nil {
+		s.Close()
+		return err
+	}
+	if s.opts.Readiness == nil {
+		defer s.Close()
+		select {
+		case <-stop:
+			return nil
+		case err := <-s.ended:
+			return err
+		}
+	}
+	backgroundServices.Add(1)
+	go func() {
+		defer backgroundServices.Done()
+		select {
+		case <-stop:
+		case <-s.ended:
+		}
+		s.Close()
+	}()
+	return nil
+}
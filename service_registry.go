@@ -0,0 +1,107 @@
+package gbtb
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// serviceProvider is a long running task that can be started as a service
+// other long running tasks depend on
+type serviceProvider interface {
+	// service starts services required by a task, builds it's dependencies and
+	// returns a service of a task. Path is a chain of services requiring it.
+	service(tasks Tasks, runner *Runner, path []string) (*service, error)
+}
+
+type serviceEntry struct {
+	ready chan struct{}
+	err   error
+	svc   *service
+}
+
+// serviceRegistry keeps services started for long running tasks that depend
+// on them, each service is started once
+type serviceRegistry struct {
+	lock    sync.Mutex
+	entries map[string]*serviceEntry
+	// order of started services
+	order []string
+}
+
+var requiredServices = serviceRegistry{entries: make(map[string]*serviceEntry)}
+
+// require starts a service named name unless it's already started and
+// waits until it's ready
+func (r *serviceRegistry) require(tasks Tasks, runner *Runner, name string, path []string) error {
+	for i, p := range path {
+		if p == name {
+			return fmt.Errorf("service dependency cycle %s", strings.Join(append(path[i:len(path):len(path)], name), " -> "))
+		}
+	}
+	r.lock.Lock()
+	e, ok := r.entries[name]
+	if !ok {
+		e = &serviceEntry{ready: make(chan struct{})}
+		r.entries[name] = e
+	}
+	r.lock.Unlock()
+	if ok {
+		<-e.ready
+		return e.err
+	}
+	defer close(e.ready)
+	provider, ok := tasks.getTask(name).(serviceProvider)
+	if !ok {
+		e.err = fmt.Errorf("service %s is not a long running task", name)
+		return e.err
+	}
+	svc, err := provider.service(tasks, runner, append(path[:len(path):len(path)], name))
+	if err == nil {
+		fmt.Printf("starting service %s\n", name)
+		if err = svc.Restart(); err != nil {
+			svc.Close()
+		}
+	}
+	if err != nil {
+		e.err = fmt.Errorf("service %s could not be started: %v", name, err)
+		return e.err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	e.svc = svc
+	r.order = append(r.order, name)
+	return nil
+}
+
+// stopAll stops started services in reverse order
+func (r *serviceRegistry) stopAll() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i := len(r.order) - 1; i >= 0; i-- {
+		fmt.Printf("stopping service %s\n", r.order[i])
+		r.entries[r.order[i]].svc.Close()
+	}
+	r.entries = make(map[string]*serviceEntry)
+	r.order = nil
+}
+
+// requireServices starts services in order, path is a chain of services requiring them
+func requireServices(tasks Tasks, runner *Runner, names []string, path ...string) error {
+	for _, name := range names {
+		if err := requiredServices.require(tasks, runner, name, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildDependencies builds dependencies of a long running task
+func buildDependencies(tasks Tasks, runner *Runner, name string, dependencies Dependencies) error {
+	t := Task{
+		Name:         name,
+		Dependencies: dependencies,
+	}
+	_, err := t.Do(tasks, runner)
+	return err
+}
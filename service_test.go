@@ -0,0 +1,127 @@
+//go:build !windows
+// +build !windows
+
+package gbtb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLogProbeOutputStreams(t *testing.T) {
+	probe := MustLogProbe("^listening on :8080$")
+	var out, errOut bytes.Buffer
+	stdoutProbe := probeOutput(&out, nil, probe)
+	stderrProbe := probeOutput(nil, &errOut, nil, probe)
+	stdoutProbe.Write([]byte("listening "))
+	stderrProbe.Write([]byte("warning\n"))
+	if probe.Check() == nil {
+		t.Fatal("probe matched a line mixed from two streams")
+	}
+	stdoutProbe.Write([]byte("on :8080\n"))
+	if err := probe.Check(); err != nil {
+		t.Error(err)
+	}
+	if out.String() != "listening on :8080\n" || errOut.String() != "warning\n" {
+		t.Errorf("output not copied, got %q and %q", out.String(), errOut.String())
+	}
+	if w := probeOutput(&out, nil, TCPProbe("localhost:0")); w != &out {
+		t.Error("output wrapped without output probes")
+	}
+}
+
+func TestServiceCommandReadinessLogProbe(t *testing.T) {
+	command := commandFactory([]string{"sh", "-c", "sleep 0.1; echo listening >&2; exec sleep 30"})
+	svc := newService("web", nil, command, false, ServiceOptions{
+		Readiness:        MustLogProbe("listening"),
+		ReadinessTimeout: 5 * time.Second,
+	})
+	defer svc.Close()
+	if err := svc.Restart(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSupervisorScriptReadinessLogProbe(t *testing.T) {
+	inTempDir(t)
+	s := &Supervisor{Name: "dev", NoColor: true, Processes: []Process{{
+		Name:   "web",
+		Script: "sleep 0.1; echo listening; sleep 30",
+		ServiceOptions: ServiceOptions{
+			Readiness:        MustLogProbe("listening"),
+			ReadinessTimeout: 5 * time.Second,
+		},
+	}, {
+		Name:   "after",
+		Script: "echo started > after.txt; sleep 30",
+	}}}
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- s.run(stop)
+	}()
+	deadline := time.After(5 * time.Second)
+	for {
+		if b, err := ioutil.ReadFile("after.txt"); err == nil && string(b) == "started\n" {
+			break
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("supervisor exited with %v", err)
+		case <-deadline:
+			t.Fatal("process after a process with log readiness probe was not started")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServiceJobCommandsGetStopOptions(t *testing.T) {
+	inTempDir(t)
+	job := StoppableCommandJob("sh", "-c", "trap 'echo term > got.txt; exit 0' TERM; while true; do sleep 0.1; done")
+	svc := newService("job", job, nil, false, ServiceOptions{
+		StopOptions: StopOptions{Signal: syscall.SIGTERM, GracePeriod: 5 * time.Second},
+	})
+	if err := svc.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	svc.Close()
+	if got := readTestFile(t, "got.txt"); got != "term\n" {
+		t.Errorf("job command got %q", got)
+	}
+}
+
+func TestServiceJobCommandsKilledAfterGracePeriod(t *testing.T) {
+	inTempDir(t)
+	// arguments are interpolated, $$$$ is $$ of shell
+	job := StoppableCommandJob("sh", "-c", "echo $$$$ > pid.txt; trap '' INT; exec sleep 30")
+	svc := newService("job", job, nil, false, ServiceOptions{
+		StopOptions: StopOptions{GracePeriod: 200 * time.Millisecond},
+	})
+	if err := svc.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	start := time.Now()
+	svc.Close()
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("job was stopped in %v", d)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(readTestFile(t, "pid.txt")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(pid, 0); err == nil {
+		syscall.Kill(pid, syscall.SIGKILL)
+		t.Error("job command was not killed")
+	}
+}
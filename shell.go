@@ -0,0 +1,78 @@
+package gbtb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// RunShellContext runs script with a built-in POSIX shell interpreter, so that
+// build does not depend on a system shell. Script is ran with process
+// environment extended with job environment. Stdout and stderr of a script
+// are handled the same way PipeCommands handles them.
+// If ctx is done, script and commands started by it are interrupted.
+func (e JobEnv) RunShellContext(ctx context.Context, script string) error {
+	return e.runShell(ctx, script, stdout, stderr)
+}
+
+// runShell runs script with a built-in POSIX shell interpreter writing it's
+// output to out and errOut
+func (e JobEnv) runShell(ctx context.Context, script string, out, errOut io.Writer) error {
+	file, err := syntax.NewParser().Parse(strings.NewReader(script), e.Name)
+	if err != nil {
+		return err
+	}
+	// use the same environment as commands ran by PipeCommands
+	cmd := exec.Cmd{Env: append([]string{}, e.Env...)}
+	addEnv(&cmd)
+	opts := []interp.RunnerOption{
+		interp.Env(expand.ListEnviron(cmd.Env...)),
+		interp.StdIO(nil, out, errOut),
+	}
+	if e.Dir != "" {
+		opts = append(opts, interp.Dir(e.Dir))
+	}
+	runner, err := interp.New(opts...)
+	if err != nil {
+		return err
+	}
+	err = runner.Run(ctx, file)
+	if status, ok := interp.IsExitStatus(err); ok {
+		return fmt.Errorf("exit status %d", status)
+	}
+	return err
+}
+
+// RunShell runs script in job environment, check out RunShellContext
+func (e JobEnv) RunShell(script string) error {
+	return e.RunShellContext(context.Background(), script)
+}
+
+// RunShell runs script with a built-in POSIX shell interpreter, check out
+// JobEnv.RunShellContext
+func RunShell(script string) error {
+	return JobEnv{}.RunShell(script)
+}
+
+// ShellJob is a convienience function that runs a script with a built-in
+// POSIX shell interpreter as a job. Supports pipes, redirects, && and ||,
+// globbing and variable expansion without depending on /bin/sh.
+func ShellJob(script string) Job {
+	return func() error {
+		return RunShell(script)
+	}
+}
+
+// ShellJobEnv runs a script with a built-in POSIX shell interpreter as a job
+// in task environment, check out ShellJob
+func ShellJobEnv(script string) EnvJob {
+	return func(env JobEnv) error {
+		return env.RunShell(script)
+	}
+}
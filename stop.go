@@ -0,0 +1,158 @@
+package gbtb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// defaultGracePeriod is a time a process has to exit after stop signal before it's killed
+const defaultGracePeriod = 10 * time.Second
+
+// StopOptions configures how stoppable commands are stopped
+type StopOptions struct {
+	// Signal sent to processes to stop them, defaults to os.Interrupt
+	Signal os.Signal
+	// GracePeriod is a time processes have to exit after Signal before
+	// they are killed, defaults to 10 seconds
+	GracePeriod time.Duration
+	// PreStop is a command with arguments run before Signal is sent, for example
+	// to drain connections. It has GracePeriod to finish.
+	PreStop []string
+}
+
+func (o StopOptions) withDefaults() StopOptions {
+	if o.Signal == nil {
+		o.Signal = os.Interrupt
+	}
+	if o.GracePeriod <= 0 {
+		o.GracePeriod = defaultGracePeriod
+	}
+	return o
+}
+
+// preStop runs PreStop command
+func (o StopOptions) preStop() {
+	if len(o.PreStop) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), o.withDefaults().GracePeriod)
+	defer cancel()
+	if err := PipeCommands(exec.CommandContext(ctx, o.PreStop[0], o.PreStop[1:]...)); err != nil {
+		fmt.Printf("pre-stop command %v failed: %v\n", o.PreStop, err)
+	}
+}
+
+// PipeCommandsContext runs a list of commands just like PipeCommandsContext function,
+// but on context done runs PreStop and signals processes with Signal, processes that
+// do not terminate in GracePeriod get killed.
+func (o StopOptions) PipeCommandsContext(ctx context.Context, cmds ...*exec.Cmd) (err error) {
+	if err := pipeCommands(false, Pty, cmds...); err != nil {
+		return err
+	}
+	wait := make(chan error)
+	go func() {
+		wait <- waitCommands(cmds...)
+	}()
+	select {
+	case err = <-wait:
+	case <-ctx.Done():
+		o = o.withDefaults()
+		o.preStop()
+		for _, cmd := range cmds {
+			signalProcess(cmd, o.Signal)
+		}
+		timer := time.NewTimer(o.GracePeriod)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			for _, cmd := range cmds {
+				killProcess(cmd)
+			}
+			err = <-wait
+		case err = <-wait:
+		}
+	}
+	return
+}
+
+// jobStopOptions maps stop channels of running Jobs of long running tasks to
+// StopOptions of tasks
+var jobStopOptions sync.Map
+
+// withJobStopOptions returns a job that stoppable commands started with it's
+// stop channel and default options are stopped according to o, just like Command
+func (o StopOptions) withJobStopOptions(job func(chan struct{}) error) func(chan struct{}) error {
+	// PreStop of a job is run once, before it's stopped
+	o.PreStop = nil
+	return func(stop chan struct{}) error {
+		jobStopOptions.Store(stop, o)
+		defer jobStopOptions.Delete(stop)
+		return job(stop)
+	}
+}
+
+// forStop returns options of a job stop channel belongs to, if o are default options
+func (o StopOptions) forStop(stop chan struct{}) StopOptions {
+	if o.Signal != nil || o.GracePeriod != 0 || len(o.PreStop) != 0 {
+		return o
+	}
+	if v, ok := jobStopOptions.Load(stop); ok {
+		return v.(StopOptions)
+	}
+	return o
+}
+
+// RunStoppableCommands runs a command pipe until it finishes or stop is closed.
+// Commands started with default options and a stop channel of a Job of long running
+// task are stopped according to StopOptions of the task.
+func (o StopOptions) RunStoppableCommands(stop chan struct{}, cmds ...*exec.Cmd) error {
+	o = o.forStop(stop)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return o.PipeCommandsContext(ctx, cmds...)
+}
+
+// RunStoppable runs a command until it finishes or stop is closed
+func (o StopOptions) RunStoppable(stop chan struct{}, name string, args ...string) error {
+	return o.RunStoppableCommands(stop, exec.Command(name, args...))
+}
+
+// StoppableCommandJob returns a stoppable job running a command stopped according to options
+func (o StopOptions) StoppableCommandJob(cmd string, args ...string) func(chan struct{}) error {
+	return func(stop chan struct{}) error {
+		return o.RunStoppable(stop, cmd, args...)
+	}
+}
+
+// killWait is a time killed processes have to exit
+const killWait = time.Second
+
+// stopJob stops a stoppable job that is not a command, PreStop is run before
+// it's stopped and if GracePeriod is set it's not waited for longer than that
+// and the time it's stoppable commands need to be killed
+func (o StopOptions) stopJob(name string, j *stoppableJob) {
+	o.preStop()
+	close(j.stop)
+	if o.GracePeriod <= 0 {
+		<-j.done
+		return
+	}
+	timer := time.NewTimer(o.GracePeriod + killWait)
+	defer timer.Stop()
+	select {
+	case <-j.done:
+	case <-timer.C:
+		fmt.Printf("%s did not stop in %v\n", name, o.GracePeriod)
+	}
+}
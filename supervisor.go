@@ -0,0 +1,302 @@
+package gbtb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prefixColors are ANSI colors of output prefixes of supervised processes
+var prefixColors = []int{36, 33, 32, 35, 34, 31}
+
+// Process is a long running process run by Supervisor
+type Process struct {
+	// Name of a process, output of a process is prefixed with it
+	Name string
+	// Job is a stoppable job of a process, it's output is not prefixed
+	Job func(chan struct{}) error
+	// Command is a command with arguments run if Job is nil
+	Command []string
+	// Script is run with a built-in POSIX shell interpreter if neither Job nor
+	// Command is set, check out ShellJob
+	Script string
+	// Env is a list of environment variables in form of KEY=VALUE set for
+	// Command and Script, check out Task.Env
+	Env []string
+	// Dir is a working directory of Command and Script
+	Dir string
+	// ServiceOptions configures health checks, restarts and stopping of a process
+	ServiceOptions
+}
+
+// Supervisor is a task running several long running processes at once with
+// output prefixed by a process name. Processes are started in order, each one
+// after the previous one is ready, and are stopped in reverse order on os.Interrupt
+// or SIGTERM, or when any of them exits.
+type Supervisor struct {
+	// Name of supervisor task
+	Name string
+	// Dependencies of supervisor task
+	Dependencies Dependencies
+	// Processes run by supervisor
+	Processes []Process
+	// Procfile is a path of a Procfile processes are loaded from and run after Processes
+	Procfile string
+	// NoColor disables colored output prefixes, they are disabled as well
+	// if NO_COLOR environment variable is set
+	NoColor bool
+}
+
+// ParseProcfile parses Procfile content, every line in form of "name: command"
+// defines a process running command with a built-in POSIX shell interpreter
+func ParseProcfile(content string) ([]Process, error) {
+	var procs []Process
+	sc := bufio.NewScanner(strings.NewReader(content))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("Procfile line %d: expected name: command", n)
+		}
+		name, command := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if command == "" {
+			return nil, fmt.Errorf("Procfile line %d: process %s has no command", n, name)
+		}
+		procs = append(procs, Process{
+			Name:   name,
+			Script: command,
+		})
+	}
+	return procs, sc.Err()
+}
+
+// LoadProcfile loads processes from Procfile at path
+func LoadProcfile(path string) ([]Process, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseProcfile(string(b))
+}
+
+// processes returns processes defined in Go and in Procfile
+func (s *Supervisor) processes() ([]Process, error) {
+	procs := append([]Process{}, s.Processes...)
+	if s.Procfile != "" {
+		fromFile, err := LoadProcfile(s.Procfile)
+		if err != nil {
+			return nil, err
+		}
+		procs = append(procs, fromFile...)
+	}
+	names := make(map[string]struct{})
+	for _, p := range procs {
+		if _, ok := names[p.Name]; ok {
+			return nil, fmt.Errorf("process %s redefined", p.Name)
+		}
+		names[p.Name] = struct{}{}
+	}
+	return procs, nil
+}
+
+// prefixWriter prefixes every line written to it
+type prefixWriter struct {
+	prefix []byte
+	w      io.Writer
+	lock   sync.Mutex
+	line   []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.line = append(p.line, b...)
+	for {
+		i := bytes.IndexByte(p.line, '\n')
+		if i < 0 {
+			break
+		}
+		if _, err := p.w.Write(append(append([]byte{}, p.prefix...), p.line[:i+1]...)); err != nil {
+			return 0, err
+		}
+		p.line = p.line[i+1:]
+	}
+	return len(b), nil
+}
+
+// prefixes returns output prefixes of processes padded to the same width
+func (s *Supervisor) prefixes(procs []Process) []string {
+	width := 0
+	for _, p := range procs {
+		if len(p.Name) > width {
+			width = len(p.Name)
+		}
+	}
+	_, noColor := os.LookupEnv("NO_COLOR")
+	noColor = noColor || s.NoColor
+	prefixes := make([]string, len(procs))
+	for i, p := range procs {
+		prefix := fmt.Sprintf("%-*s | ", width, p.Name)
+		if !noColor {
+			prefix = fmt.Sprintf("\x1b[%dm%s\x1b[0m", prefixColors[i%len(prefixColors)], prefix)
+		}
+		prefixes[i] = prefix
+	}
+	return prefixes
+}
+
+// service returns a service running process with prefixed output
+func (p Process) service(prefix string) (*service, error) {
+	if p.Job != nil || (len(p.Command) == 0 && p.Script == "") {
+		return newService(p.Name, p.Job, nil, false, p.ServiceOptions), nil
+	}
+	env, err := NewJobEnv(p.Name, p.Env, p.Dir)
+	if err != nil {
+		return nil, err
+	}
+	out := &prefixWriter{prefix: []byte(prefix), w: stdout}
+	errOut := &prefixWriter{prefix: []byte(prefix), w: stderr}
+	if len(p.Command) == 0 {
+		script := p.Script
+		job := func(stop chan struct{}) error {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				select {
+				case <-stop:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			err := env.runShell(ctx, script,
+				probeOutput(out, nil, p.Readiness, p.Liveness),
+				probeOutput(errOut, nil, p.Readiness, p.Liveness))
+			select {
+			case <-stop:
+				// interrupted on request
+				return nil
+			default:
+				return err
+			}
+		}
+		return newService(p.Name, job, nil, false, p.ServiceOptions), nil
+	}
+	command := func() *exec.Cmd {
+		cmd := env.Command(p.Command[0], p.Command[1:]...)
+		cmd.Stdout, cmd.Stderr = out, errOut
+		return cmd
+	}
+	return newService(p.Name, nil, command, false, p.ServiceOptions), nil
+}
+
+// startServices starts services in order, each one once the previous one is ready.
+// If any fails to start, services that were not started yet are closed and
+// already started ones are stopped in reverse order.
+func startServices(names []string, services []*service) error {
+	for i, svc := range services {
+		fmt.Printf("starting %s\n", names[i])
+		if err := svc.Restart(); err != nil {
+			for _, s := range services[i+1:] {
+				s.Close()
+			}
+			stopServices(names[:i+1], services[:i+1])
+			return err
+		}
+	}
+	return nil
+}
+
+// stopServices stops services in reverse order
+func stopServices(names []string, services []*service) {
+	for i := len(services) - 1; i >= 0; i-- {
+		fmt.Printf("stopping %s\n", names[i])
+		services[i].Close()
+	}
+}
+
+// run runs processes until stop is closed or any of them ends
+func (s *Supervisor) run(stop chan struct{}) error {
+	procs, err := s.processes()
+	if err != nil {
+		return err
+	}
+	if len(procs) == 0 {
+		return fmt.Errorf("supervisor %s has no processes", s.Name)
+	}
+	prefixes := s.prefixes(procs)
+	names := make([]string, len(procs))
+	services := make([]*service, 0, len(procs))
+	for i, p := range procs {
+		svc, err := p.service(prefixes[i])
+		if err != nil {
+			stopServices(names[:i], services)
+			return err
+		}
+		names[i] = p.Name
+		services = append(services, svc)
+	}
+	if err := startServices(names, services); err != nil {
+		return err
+	}
+	ended := make(chan string, len(services))
+	done := make(chan struct{})
+	defer close(done)
+	for i, svc := range services {
+		go func(name string, svc *service) {
+			select {
+			case <-svc.ended:
+				ended <- name
+			case <-done:
+			}
+		}(names[i], svc)
+	}
+	select {
+	case <-stop:
+		err = nil
+	case name := <-ended:
+		err = fmt.Errorf("process %s exited", name)
+	}
+	stopServices(names, services)
+	return err
+}
+
+// Do runs processes until os.Interrupt or SIGTERM
+func (s *Supervisor) Do(tasks Tasks, runner *Runner) (time.Time, error) {
+	stop, release := interruptChannel()
+	defer release()
+	t := Task{
+		Name:         s.Name,
+		Dependencies: s.Dependencies,
+		Job: Job(func() error {
+			return s.run(stop)
+		}),
+	}
+	return t.Do(tasks, runner)
+}
+
+// DependsOn for supervisor task
+func (s *Supervisor) DependsOn() Dependencies {
+	return s.Dependencies
+}
+
+// Reset the supervisor task
+func (s *Supervisor) Reset() {}
+
+func (s *Supervisor) GetNames() []string { return []string{s.Name} }
+func (s *Supervisor) GetTask(name string) TaskLike {
+	if name != s.Name {
+		return nil
+	}
+	return s
+}
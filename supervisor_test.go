@@ -0,0 +1,68 @@
+//go:build !windows
+// +build !windows
+
+package gbtb
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProcfile(t *testing.T) {
+	procs, err := ParseProcfile("# comment\nweb: echo a | tr a b\n\nworker:  sleep 1 && echo done \n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Process{
+		{Name: "web", Script: "echo a | tr a b"},
+		{Name: "worker", Script: "sleep 1 && echo done"},
+	}
+	if len(procs) != len(expected) {
+		t.Fatalf("parsed %d processes, expected %d", len(procs), len(expected))
+	}
+	for i, p := range procs {
+		if p.Name != expected[i].Name || p.Script != expected[i].Script || p.Command != nil {
+			t.Errorf("process %d parsed as %+v, expected %+v", i, p, expected[i])
+		}
+	}
+	for _, content := range []string{"web", "web:", ": echo"} {
+		if _, err := ParseProcfile(content); err == nil {
+			t.Errorf("expected an error parsing %q", content)
+		}
+	}
+}
+
+func TestSupervisorRunsProcfileWithShellInterpreter(t *testing.T) {
+	inTempDir(t)
+	writeTestFile(t, "Procfile", "web: echo \"$TASK_NAME\" | tr a-z A-Z > out.txt\n", 0644)
+	s := &Supervisor{Name: "dev", Procfile: "Procfile", NoColor: true}
+	err := s.run(make(chan struct{}))
+	if err == nil || !strings.Contains(err.Error(), "process web exited") {
+		t.Fatalf("expected web to exit, got %v", err)
+	}
+	if got := readTestFile(t, "out.txt"); got != "WEB\n" {
+		t.Errorf("script wrote %q", got)
+	}
+}
+
+func TestSupervisorStopsProcfileScripts(t *testing.T) {
+	inTempDir(t)
+	writeTestFile(t, "Procfile", "web: sleep 30\n", 0644)
+	s := &Supervisor{Name: "dev", Procfile: "Procfile", NoColor: true}
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- s.run(stop)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	close(stop)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected no error on stop, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("script was not stopped")
+	}
+}
@@ -3,6 +3,7 @@ package gbtb
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -20,11 +21,35 @@ type Task struct {
 	Dependencies Dependencies
 	// Job ran by task
 	Job Job
+	// EnvJob is a job ran by task in task environment, it gets JobEnv with Env
+	// and Dir of the task. If both Job and EnvJob are set, EnvJob runs after Job.
+	EnvJob EnvJob
+	// Env is a list of environment variables in form of KEY=VALUE set for
+	// commands ran by EnvJob. Values can reference other variables with ${VAR},
+	// name of the task is available as ${TASK_NAME}. Plain Job does not get
+	// task environment, so a task setting Env, Dir or Pty without EnvJob fails.
+	Env []string
+	// Dir is a working directory of commands ran by EnvJob, it can reference
+	// variables with ${VAR} just like Env.
+	Dir string
+	// Pty runs commands of EnvJob under pseudo-terminal to preserve colors
+	// of tools that disable them when output is not a terminal, check out Pty
+	Pty bool
 	// ModTime is a function that allows user to override default behaviour
 	// testing when was the target updated last time. For example docker image
 	// creation date. If not provided, a mod time of a file with the same
 	// name as task name is used.
 	ModTime ModTime
+	// Outputs is a list of files produced by Job. Outputs are stored in
+	// and restored from Cache.
+	Outputs []string
+	// Cache is an optional cache of task outputs. If there's an entry for
+	// a task inputs in cache, outputs are restored from it instead of running Job.
+	Cache Cache
+	// Fingerprint identifies a Job in cache key, for example a command line
+	Fingerprint string
+	// CacheEnv is a list of environment variables names included in cache key
+	CacheEnv []string
 
 	modTime time.Time
 	done    bool
@@ -55,11 +80,17 @@ func (t *Task) Do(tasks Tasks, runner *Runner) (time.Time, error) {
 	if t.done {
 		return t.modTime, t.err
 	}
+	if t.EnvJob == nil && (len(t.Env) > 0 || t.Dir != "" || t.Pty) {
+		return t.modTime, fmt.Errorf("task %s sets Env, Dir or Pty without EnvJob, use EnvJob with *Env helpers like GoBuildEnv", t.Name)
+	}
 	wg := sync.WaitGroup{}
 	var dependencyFailures []string
 	dependencyFailureCh := make(chan string)
 	var timestamps []time.Time
 	timestampCh := make(chan time.Time)
+	// mod times of dependencies that are tasks
+	taskTimes := make(map[string]time.Time)
+	var taskTimesLock sync.Mutex
 	// monitor errors of dependant tasks
 	waitForDependencies, waitForTimestamps := make(chan struct{}), make(chan struct{})
 	go func() {
@@ -76,9 +107,10 @@ func (t *Task) Do(tasks Tasks, runner *Runner) (time.Time, error) {
 		close(waitForTimestamps)
 	}()
 	var err error
-	var dependencies []string
+	var dependencies, allDependencies []string
 	if t.Dependencies != nil {
 		dependencies, err = t.Dependencies.Get()
+		allDependencies = dependencies
 	}
 	if err == nil {
 		t.modTime, err = t.getModtime()
@@ -99,11 +131,11 @@ func (t *Task) Do(tasks Tasks, runner *Runner) (time.Time, error) {
 		}
 		if depTask == nil {
 			var t time.Time
-			v, ok := fileModTimeCache.Load(dep)
+			v, ok := fileModTimeCache.Load(filepath.Clean(dep))
 			if !ok {
 				t, err = fileDependency(dep)
 				if err == nil {
-					fileModTimeCache.Store(dep, t)
+					fileModTimeCache.Store(filepath.Clean(dep), t)
 				} else {
 					dependencyFailureCh <- dep
 				}
@@ -120,6 +152,9 @@ func (t *Task) Do(tasks Tasks, runner *Runner) (time.Time, error) {
 					dependencyFailureCh <- dep
 					return
 				}
+				taskTimesLock.Lock()
+				taskTimes[dep] = t
+				taskTimesLock.Unlock()
 				timestampCh <- t
 			}()
 		}
@@ -142,8 +177,7 @@ func (t *Task) Do(tasks Tasks, runner *Runner) (time.Time, error) {
 			}
 		}
 		if !upToDate {
-			fmt.Printf("building %s\n", t.Name)
-			t.err = runner.Put(t.Job)
+			t.err = t.build(tasks, runner, allDependencies, taskTimes)
 			if t.err == nil {
 				// refresh modTime after update
 				t.modTime, t.err = t.getModtime()
@@ -159,6 +193,92 @@ func (t *Task) Do(tasks Tasks, runner *Runner) (time.Time, error) {
 	return t.modTime, t.err
 }
 
+func (t *Task) cacheKey(tasks Tasks, dependencies []string, taskTimes map[string]time.Time) (string, error) {
+	sums := make(map[string]string, len(taskTimes))
+	for dep, modTime := range taskTimes {
+		var outputs []string
+		if dt, ok := tasks.getTask(dep).(*Task); ok {
+			outputs = dt.Outputs
+		}
+		sum, err := taskSum(outputs, modTime)
+		if err != nil {
+			return "", err
+		}
+		sums[dep] = sum
+	}
+	env, err := t.jobEnv()
+	if err != nil {
+		return "", err
+	}
+	return CacheKey{
+		Name:         t.Name,
+		Dependencies: dependencies,
+		TaskSums:     sums,
+		Dir:          env.Dir,
+		JobEnv:       env.Env,
+		Fingerprint:  t.Fingerprint,
+		Env:          t.CacheEnv,
+		Outputs:      t.Outputs,
+	}.Sum()
+}
+
+func (t *Task) runJob(runner *Runner) error {
+	if t.EnvJob == nil {
+		return runner.Put(t.Job)
+	}
+	env, err := t.jobEnv()
+	if err != nil {
+		return err
+	}
+	job, envJob := t.Job, t.EnvJob
+	return runner.Put(func() error {
+		if job != nil {
+			if err := job(); err != nil {
+				return err
+			}
+		}
+		return envJob(env)
+	})
+}
+
+// jobEnv returns an environment EnvJob of a task runs in
+func (t *Task) jobEnv() (JobEnv, error) {
+	env, err := NewJobEnv(t.Name, t.Env, t.Dir)
+	if err != nil {
+		return JobEnv{}, err
+	}
+	env.Pty = t.Pty
+	return env, nil
+}
+
+// build runs a job or restores task outputs from cache if possible
+func (t *Task) build(tasks Tasks, runner *Runner, dependencies []string, taskTimes map[string]time.Time) error {
+	if t.Cache == nil || len(t.Outputs) == 0 {
+		fmt.Printf("building %s\n", t.Name)
+		return t.runJob(runner)
+	}
+	key, err := t.cacheKey(tasks, dependencies, taskTimes)
+	if err != nil {
+		return err
+	}
+	hit, err := t.Cache.Restore(key, t.Outputs)
+	if err != nil {
+		fmt.Printf("task %s cache restore failed: %v\n", t.Name, err)
+	}
+	if hit {
+		fmt.Printf("task %s restored from cache\n", t.Name)
+		return nil
+	}
+	fmt.Printf("building %s\n", t.Name)
+	if err := t.runJob(runner); err != nil {
+		return err
+	}
+	if err := t.Cache.Store(key, t.Outputs); err != nil {
+		fmt.Printf("task %s cache store failed: %v\n", t.Name, err)
+	}
+	return nil
+}
+
 func (t *Task) DependsOn() Dependencies {
 	return t.Dependencies
 }
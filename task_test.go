@@ -0,0 +1,63 @@
+package gbtb
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestRunner(t *testing.T) *Runner {
+	r := &Runner{}
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Stop)
+	return r
+}
+
+func TestTaskEnvRequiresEnvJob(t *testing.T) {
+	ran := false
+	job := func() error {
+		ran = true
+		return nil
+	}
+	for _, task := range []*Task{
+		{Name: "env", Env: []string{"FOO=1"}, Job: job},
+		{Name: "dir", Dir: "sub", Job: job},
+		{Name: "pty", Pty: true, Job: job},
+	} {
+		_, err := task.Do(Tasks{task}, newTestRunner(t))
+		if err == nil || !strings.Contains(err.Error(), "EnvJob") {
+			t.Errorf("task %s: expected an error about EnvJob, got %v", task.Name, err)
+		}
+	}
+	if ran {
+		t.Error("job ran without task environment")
+	}
+}
+
+func TestTaskEnvJobGetsTaskEnvironment(t *testing.T) {
+	inTempDir(t)
+	var got JobEnv
+	task := &Task{
+		Name: "app",
+		Env:  []string{"OUT=${TASK_NAME}.bin"},
+		Dir:  "sub",
+		EnvJob: func(env JobEnv) error {
+			got = env
+			return nil
+		},
+	}
+	if _, err := task.Do(Tasks{task}, newTestRunner(t)); err != nil {
+		t.Fatal(err)
+	}
+	if got.Dir != "sub" {
+		t.Errorf("EnvJob got Dir %q", got.Dir)
+	}
+	found := false
+	for _, e := range got.Env {
+		found = found || e == "OUT=app.bin"
+	}
+	if !found {
+		t.Errorf("EnvJob got Env %v without OUT=app.bin", got.Env)
+	}
+}
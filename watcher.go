@@ -0,0 +1,399 @@
+package gbtb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// WatchOp is a set of file system operations reported by watcher
+type WatchOp uint32
+
+// File system operations
+const (
+	WatchCreate = WatchOp(fsnotify.Create)
+	WatchWrite  = WatchOp(fsnotify.Write)
+	WatchRemove = WatchOp(fsnotify.Remove)
+	WatchRename = WatchOp(fsnotify.Rename)
+	WatchChmod  = WatchOp(fsnotify.Chmod)
+)
+
+// watchEvent is a change of a watched path
+type watchEvent struct {
+	Name string
+	Op   WatchOp
+}
+
+// defaultDebounce is a quiet period after last change before a rebuild
+const defaultDebounce = time.Second
+
+// watchOptions configures which changes are reported by watchSession
+type watchOptions struct {
+	// debounce is a quiet period after last change before a rebuild
+	debounce time.Duration
+	// ignore matches paths which changes are ignored and which are not watched
+	ignore ignoreMatcher
+	// ignoreOps are operations which are ignored
+	ignoreOps WatchOp
+}
+
+func newWatchOptions(debounce time.Duration, ignore []string, gitIgnore bool, ignoreOps WatchOp) (watchOptions, error) {
+	opts := watchOptions{
+		debounce:  debounce,
+		ignore:    newIgnoreMatcher(ignore),
+		ignoreOps: ignoreOps,
+	}
+	if opts.debounce <= 0 {
+		opts.debounce = defaultDebounce
+	}
+	if gitIgnore {
+		var err error
+		if opts.ignore, err = opts.ignore.loadGitIgnore(); err != nil {
+			return watchOptions{}, err
+		}
+	}
+	return opts, nil
+}
+
+// fileWatcher is an abstraction over file system notifications used by Notify
+type fileWatcher interface {
+	// Add starts watching a file or a directory
+	Add(name string) error
+	// Remove stops watching a file or a directory
+	Remove(name string) error
+	// Events returns a channel of changes
+	Events() <-chan watchEvent
+	// Errors returns a channel of watcher errors
+	Errors() <-chan error
+	Close() error
+}
+
+var errWatcherClosed = errors.New("watcher error")
+
+type fsnotifyWatcher struct {
+	*fsnotify.Watcher
+	events chan watchEvent
+	done   chan struct{}
+}
+
+func newFsnotifyWatcher() (*fsnotifyWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	fw := &fsnotifyWatcher{w, make(chan watchEvent), make(chan struct{})}
+	go func() {
+		defer close(fw.events)
+		for ev := range w.Events {
+			select {
+			case fw.events <- watchEvent{filepath.Clean(ev.Name), WatchOp(ev.Op)}:
+			case <-fw.done:
+				return
+			}
+		}
+	}()
+	return fw, nil
+}
+
+func (f *fsnotifyWatcher) Close() error {
+	close(f.done)
+	return f.Watcher.Close()
+}
+
+func (f *fsnotifyWatcher) Events() <-chan watchEvent {
+	return f.events
+}
+
+func (f *fsnotifyWatcher) Errors() <-chan error {
+	return f.Watcher.Errors
+}
+
+// recursiveDir returns a directory without "/..." suffix and true if
+// directory should be watched recursively
+func recursiveDir(dir string) (string, bool) {
+	suffix := string(filepath.Separator) + "..."
+	if strings.HasSuffix(dir, suffix) {
+		return strings.TrimSuffix(dir, suffix), true
+	}
+	return dir, false
+}
+
+// watchDeps is a resolved dependency graph of watched tasks
+type watchDeps struct {
+	// files watched tasks depend on directly or through dependencies
+	files map[string]struct{}
+	// dirs watched for new files, true if watched recursively
+	dirs map[string]bool
+	// direct maps a task to files it depends on directly
+	direct map[string]map[string]struct{}
+	// dependents maps a task to tasks directly depending on it
+	dependents map[string]map[string]struct{}
+}
+
+func newWatchDeps() watchDeps {
+	return watchDeps{
+		files:      make(map[string]struct{}),
+		dirs:       make(map[string]bool),
+		direct:     make(map[string]map[string]struct{}),
+		dependents: make(map[string]map[string]struct{}),
+	}
+}
+
+func (w watchDeps) resolve(tasks Tasks, name string, task TaskLike) error {
+	w.direct[name] = make(map[string]struct{})
+	if task.DependsOn() == nil {
+		return nil
+	}
+	if wd, ok := task.DependsOn().(WatchedDependencies); ok {
+		for _, d := range wd.WatchDirs() {
+			dir, recursive := recursiveDir(d)
+			w.dirs[dir] = w.dirs[dir] || recursive
+		}
+	}
+	deps, err := task.DependsOn().Get()
+	if err != nil {
+		return err
+	}
+	for _, dep := range deps {
+		if tDep := tasks.getTask(dep); tDep != nil {
+			if w.dependents[dep] == nil {
+				w.dependents[dep] = make(map[string]struct{})
+			}
+			w.dependents[dep][name] = struct{}{}
+			if _, ok := w.direct[dep]; ok {
+				// already resolved
+				continue
+			}
+			if err := w.resolve(tasks, dep, tDep); err != nil {
+				return err
+			}
+		} else {
+			w.files[filepath.Clean(dep)] = struct{}{}
+			w.direct[name][filepath.Clean(dep)] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// affected returns tasks that directly depend on any of changed files
+// or which direct file dependencies differ from those in prev,
+// along with all tasks depending on them
+func (w watchDeps) affected(prev watchDeps, changed map[string]struct{}) map[string]struct{} {
+	var queue []string
+	for name, files := range w.direct {
+		prevFiles, ok := prev.direct[name]
+		if !ok || !sameFiles(files, prevFiles) {
+			queue = append(queue, name)
+			continue
+		}
+		for f := range changed {
+			if _, ok := files[f]; ok {
+				queue = append(queue, name)
+				break
+			}
+		}
+	}
+	affected := make(map[string]struct{})
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if _, ok := affected[name]; ok {
+			continue
+		}
+		affected[name] = struct{}{}
+		for d := range w.dependents[name] {
+			queue = append(queue, d)
+		}
+	}
+	return affected
+}
+
+// watchPaths returns all paths that must be watched to detect changes
+// in dependencies. Base directories of files are watched as well, because
+// certain editors replace a file on write.
+// Ignored paths and directories are not watched.
+func (w watchDeps) watchPaths(ignore ignoreMatcher) map[string]struct{} {
+	paths := make(map[string]struct{})
+	add := func(p string) {
+		if !ignore.Match(p) {
+			paths[p] = struct{}{}
+		}
+	}
+	for f := range w.files {
+		add(f)
+		add(filepath.Dir(f))
+	}
+	for d, recursive := range w.dirs {
+		if !recursive {
+			add(d)
+			continue
+		}
+		filepath.Walk(d, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.IsDir() {
+				return nil
+			}
+			if ignore.Match(path) {
+				return filepath.SkipDir
+			}
+			paths[path] = struct{}{}
+			return nil
+		})
+	}
+	return paths
+}
+
+// inWatchedDir returns true if name is in a directory watched for new files
+func (w watchDeps) inWatchedDir(name string) bool {
+	for dir := filepath.Dir(name); ; dir = filepath.Dir(dir) {
+		if recursive, ok := w.dirs[dir]; ok && (recursive || dir == filepath.Dir(name)) {
+			return true
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return false
+		}
+	}
+}
+
+func sameFiles(a, b map[string]struct{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// watchSession watches dependencies of tasks, re-evaluating them after
+// every change
+type watchSession struct {
+	tasks   Tasks
+	roots   map[string]TaskLike
+	watcher fileWatcher
+	opts    watchOptions
+
+	lock    sync.Mutex
+	deps    watchDeps
+	watched map[string]struct{}
+}
+
+// newWatchSession starts watching dependencies of roots, a map of watched
+// tasks by their names
+func newWatchSession(tasks Tasks, roots map[string]TaskLike, opts watchOptions) (*watchSession, error) {
+	w := newFileWatcher()
+	s := &watchSession{
+		tasks:   tasks,
+		roots:   roots,
+		watcher: w,
+		opts:    opts,
+		watched: make(map[string]struct{}),
+	}
+	if _, err := s.refresh(nil); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// refresh re-evaluates dependencies of watched tasks and updates watched paths,
+// returns names of tasks affected by changed files, check out watchDeps.affected.
+func (s *watchSession) refresh(changed map[string]struct{}) (map[string]struct{}, error) {
+	defer cacheGoList()()
+	deps := newWatchDeps()
+	for name, task := range s.roots {
+		if _, ok := deps.direct[name]; ok {
+			// watched task is a dependency of other watched task
+			continue
+		}
+		if err := deps.resolve(s.tasks, name, task); err != nil {
+			return nil, err
+		}
+	}
+	paths := deps.watchPaths(s.opts.ignore)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var affected map[string]struct{}
+	if s.deps.files != nil {
+		affected = deps.affected(s.deps, changed)
+	}
+	s.deps = deps
+	for p := range s.watched {
+		if _, ok := paths[p]; !ok {
+			s.watcher.Remove(p)
+			delete(s.watched, p)
+		}
+	}
+	for p := range paths {
+		if _, ok := s.watched[p]; ok {
+			continue
+		}
+		if err := s.watcher.Add(p); err != nil {
+			if os.IsNotExist(err) {
+				// dependency does not exist yet, it's base directory is watched
+				continue
+			}
+			return affected, err
+		}
+		s.watched[p] = struct{}{}
+	}
+	return affected, nil
+}
+
+// allTasks returns names of all watched tasks and their dependencies
+func (s *watchSession) allTasks() map[string]struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	all := make(map[string]struct{})
+	for name := range s.deps.direct {
+		all[name] = struct{}{}
+	}
+	return all
+}
+
+// relevant returns true if a change can affect dependencies of a task
+func (s *watchSession) relevant(ev watchEvent) bool {
+	if ev.Op&^s.opts.ignoreOps == 0 || s.opts.ignore.Match(ev.Name) {
+		return false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.deps.files[ev.Name]; ok {
+		return true
+	}
+	return s.deps.inWatchedDir(ev.Name)
+}
+
+// watch sends names of changed paths to changes until stop is closed
+// or watcher fails
+func (s *watchSession) watch(changes chan string, stop chan struct{}) error {
+	defer close(changes)
+	for {
+		select {
+		case <-stop:
+			return nil
+		case ev, ok := <-s.watcher.Events():
+			if !ok {
+				return errWatcherClosed
+			}
+			if s.relevant(ev) {
+				fileModTimeCache.Delete(ev.Name)
+				changes <- ev.Name
+			}
+		case _, ok := <-s.watcher.Errors():
+			if !ok {
+				return errWatcherClosed
+			}
+		}
+	}
+}
+
+func (s *watchSession) Close() error {
+	return s.watcher.Close()
+}
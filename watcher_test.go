@@ -0,0 +1,94 @@
+package gbtb
+
+import (
+	"testing"
+)
+
+func newTestWatchSession(t *testing.T, tasks Tasks, roots ...string) *watchSession {
+	opts, err := newWatchOptions(0, nil, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watched := make(map[string]TaskLike)
+	for _, name := range roots {
+		watched[name] = tasks.getTask(name)
+	}
+	s, err := newWatchSession(tasks, watched, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestWatchSessionGlobFilesNewFiles(t *testing.T) {
+	inTempDir(t)
+	writeTestFile(t, "main.go", "package main\n", 0644)
+	tasks := Tasks{&Task{Name: "app", Dependencies: GlobFiles("**/*.go")}}
+	s := newTestWatchSession(t, tasks, "app")
+	writeTestFile(t, "sub/new.go", "package sub\n", 0644)
+	for _, name := range []string{"sub", "sub/new.go"} {
+		if !s.relevant(watchEvent{Name: name, Op: WatchCreate}) {
+			t.Errorf("creating %s is not relevant", name)
+		}
+	}
+	affected, err := s.refresh(map[string]struct{}{"sub/new.go": {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := affected["app"]; !ok {
+		t.Errorf("app is not affected by a new file, affected %v", affected)
+	}
+}
+
+// testWatchDeps returns resolved dependencies of app depending on lib and
+// main.go, lib depending on files of lib and gen, and gen depending on gen.txt
+func testWatchDeps(t *testing.T, libFiles ...string) watchDeps {
+	tasks := Tasks{
+		&Task{Name: "app", Dependencies: StaticDependencies{"lib", "main.go"}},
+		&Task{Name: "lib", Dependencies: append(StaticDependencies{"gen"}, libFiles...)},
+		&Task{Name: "gen", Dependencies: StaticDependencies{"gen.txt"}},
+		&Task{Name: "other", Dependencies: StaticDependencies{"other.go"}},
+	}
+	deps := newWatchDeps()
+	for _, name := range []string{"app", "other"} {
+		if err := deps.resolve(tasks, name, tasks.getTask(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return deps
+}
+
+func TestWatchDepsAffected(t *testing.T) {
+	prev := testWatchDeps(t, "lib.go")
+	for _, tc := range []struct {
+		name     string
+		deps     watchDeps
+		changed  []string
+		affected []string
+	}{
+		{"no changes", testWatchDeps(t, "lib.go"), nil, nil},
+		{"unrelated file", testWatchDeps(t, "lib.go"), []string{"README.md"}, nil},
+		{"direct file of a root", testWatchDeps(t, "lib.go"), []string{"main.go"}, []string{"app"}},
+		{"direct file of a dependency", testWatchDeps(t, "lib.go"), []string{"lib.go"}, []string{"app", "lib"}},
+		{"transitive dependency", testWatchDeps(t, "lib.go"), []string{"gen.txt"}, []string{"app", "gen", "lib"}},
+		{"other root", testWatchDeps(t, "lib.go"), []string{"other.go"}, []string{"other"}},
+		{"file added to a task", testWatchDeps(t, "lib.go", "new.go"), []string{"new.go"}, []string{"app", "lib"}},
+		{"file set changed without changes", testWatchDeps(t, "new.go"), nil, []string{"app", "lib"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			changed := make(map[string]struct{})
+			for _, f := range tc.changed {
+				changed[f] = struct{}{}
+			}
+			affected := tc.deps.affected(prev, changed)
+			expected := make(map[string]struct{})
+			for _, name := range tc.affected {
+				expected[name] = struct{}{}
+			}
+			if !sameFiles(affected, expected) {
+				t.Errorf("affected %v, expected %v", affected, expected)
+			}
+		})
+	}
+}